@@ -0,0 +1,166 @@
+package tron
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	BATCH_ITEM_HEADER = 8         // 每项的 seq 与数据长度
+	BATCH_FRAME_LEN   = 64 * 1024 // 单个批量帧的最大数据长度，超过的项单独发送
+)
+
+var ERR_BATCH_TIMEOUT = errors.New("batch item timeout")
+
+// 批量请求中一项的结果
+type BatchResult struct {
+	Resp interface{} // handler 通过 NotifyReceived 传回的响应
+	Err  error       // 限速拒绝、超时等
+}
+
+// 批量发送请求，返回与 reqs 一一对应的响应 channel，无需响应的项为 nil
+// 对端协商支持时多个小请求打包为一个 FLAG_BATCH 帧，否则逐个排队，由写协程合并为一次 flush
+// 不在此发起协商，旧版本 server 会将 hello 当作普通请求，需打包时以 WithBatch 在 Dial 时协商
+func (c *Client) AsyncWriteBatch(reqs []*Packet) ([]chan interface{}, error) {
+	respChs := make([]chan interface{}, len(reqs))
+	for i, p := range reqs {
+		if p.Header.Seq >= 0 || p.OneWay() {
+			continue
+		}
+		p.Header.Seq = c.conf.SeqManager.NextSeq()
+		respChs[i] = make(chan interface{}, 1)
+		c.conf.SeqManager.AddSeq(p.Header.Seq, respChs[i])
+	}
+
+	frames := reqs
+	if c.session.PeerFlags()&FLAG_BATCH != 0 {
+		frames = packBatch(reqs)
+	}
+	for _, p := range frames {
+		if err := c.session.enqueue(p, PRIORITY_NORMAL, true); err != nil {
+			for i, p := range reqs {
+				if respChs[i] != nil {
+					c.conf.SeqManager.DropSeq(p.Header.Seq, respChs[i])
+				}
+			}
+			return nil, err
+		}
+	}
+	return respChs, nil
+}
+
+// 批量发送请求并等待全部响应，timeout 为整批的等待时长
+// 写入失败时返回 error，各项的错误在对应的 BatchResult 中
+func (c *Client) SyncWriteBatch(reqs []*Packet, timeout time.Duration) ([]BatchResult, error) {
+	respChs, err := c.AsyncWriteBatch(reqs)
+	if err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	results := make([]BatchResult, len(reqs))
+	expired := false
+	for i, ch := range respChs {
+		if ch == nil { // 单向消息或响应包
+			continue
+		}
+		var resp interface{}
+		received := false // 响应本身可能为 nil，不能以此判断超时
+		if expired {
+			select {
+			case resp = <-ch:
+				received = true
+			default:
+			}
+		} else {
+			select {
+			case resp = <-ch:
+				received = true
+			case <-timer.C:
+				expired = true
+			}
+		}
+		if !received {
+			c.conf.SeqManager.DropSeq(reqs[i].Header.Seq, ch)
+			results[i].Err = ERR_BATCH_TIMEOUT
+			continue
+		}
+		if err, ok := resp.(error); ok { // 如 ERR_OVERLOAD
+			results[i].Err = err
+			continue
+		}
+		results[i].Resp = resp
+	}
+	return results, nil
+}
+
+// 将小请求依次打包为批量帧，数据为多个 seq + 长度 + 数据
+// 单独超过 BATCH_FRAME_LEN 的请求原样发送，整体顺序不变
+func packBatch(reqs []*Packet) []*Packet {
+	var frames, pending []*Packet
+	size := 0
+	flush := func() {
+		if len(pending) == 1 { // 单项无需打包
+			frames = append(frames, pending[0])
+		} else if len(pending) > 1 {
+			buf := make([]byte, 0, size)
+			for _, p := range pending {
+				buf = appendBatchItem(buf, p)
+			}
+			p := NewRespPacket(SEQ_BATCH, buf)
+			p.Header.Flags = FLAG_BATCH
+			frames = append(frames, p)
+		}
+		pending, size = pending[:0], 0
+	}
+	for _, p := range reqs {
+		n := BATCH_ITEM_HEADER + len(p.Data)
+		if n > BATCH_FRAME_LEN {
+			flush()
+			frames = append(frames, p)
+			continue
+		}
+		if size+n > BATCH_FRAME_LEN {
+			flush()
+		}
+		pending = append(pending, p)
+		size += n
+	}
+	flush()
+	return frames
+}
+
+func appendBatchItem(buf []byte, p *Packet) []byte {
+	var hdr [BATCH_ITEM_HEADER]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(p.Header.Seq))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(len(p.Data)))
+	buf = append(buf, hdr[:]...)
+	return append(buf, p.Data...)
+}
+
+// 拆开批量帧，各项与普通包一样逐个限速与分发
+// 各项的 Data 引用 p 的数据，p 的 buffer 不再归还到池中
+func unbatch(p *Packet) ([]*Packet, error) {
+	data := p.Data
+	var items []*Packet
+	for len(data) > 0 {
+		if len(data) < BATCH_ITEM_HEADER {
+			return nil, &ProtocolError{fmt.Errorf("%w: truncated batch item", ERR_PACKET_LEN_INVALID)}
+		}
+		seq := int32(binary.BigEndian.Uint32(data))
+		n := int(binary.BigEndian.Uint32(data[4:]))
+		if n < 0 || n > len(data)-BATCH_ITEM_HEADER {
+			return nil, &ProtocolError{fmt.Errorf("%w: batch item length %d", ERR_PACKET_LEN_INVALID, n)}
+		}
+		if seq < 0 && seq != SEQ_ONEWAY { // 控制包不可打包
+			return nil, &ProtocolError{fmt.Errorf("invalid batch item seq %d", seq)}
+		}
+		items = append(items, NewRespPacket(seq, data[BATCH_ITEM_HEADER:BATCH_ITEM_HEADER+n:BATCH_ITEM_HEADER+n]))
+		data = data[BATCH_ITEM_HEADER+n:]
+	}
+	p.buf = nil
+	return items, nil
+}
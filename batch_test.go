@@ -0,0 +1,41 @@
+package tron
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestBatchFrameRoundTrip(t *testing.T) {
+	var reqs []*Packet
+	for i := 0; i < 20; i++ {
+		reqs = append(reqs, NewRespPacket(int32(i), []byte(fmt.Sprintf("req-%d", i))))
+	}
+	reqs = append(reqs, NewRespPacket(20, make([]byte, BATCH_FRAME_LEN)), NewOneWayPacket([]byte("event")))
+
+	frames := packBatch(reqs)
+	if len(frames) != 3 || frames[0].Header.Flags != FLAG_BATCH || frames[1] != reqs[20] || frames[2] != reqs[21] {
+		t.Fatalf("want batch + large item + single item, got %d frames", len(frames))
+	}
+	items, err := unbatch(frames[0])
+	if err != nil {
+		t.Fatalf("unbatch failed: %v", err)
+	}
+	if len(items) != 20 {
+		t.Fatalf("got %d items, want 20", len(items))
+	}
+	for i, item := range items {
+		if item.Header.Seq != reqs[i].Header.Seq || !bytes.Equal(item.Data, reqs[i].Data) {
+			t.Fatalf("item %d: got seq %d %q", i, item.Header.Seq, item.Data)
+		}
+	}
+
+	bad := NewRespPacket(SEQ_BATCH, appendBatchItem(nil, NewHelloPacket(HELLO_OFFER, 0)))
+	if _, err := unbatch(bad); !IsProtocolError(err) {
+		t.Fatalf("control packet in batch: want protocol error, got %v", err)
+	}
+	bad = NewRespPacket(SEQ_BATCH, frames[0].Data[:len(frames[0].Data)-1])
+	if _, err := unbatch(bad); !IsProtocolError(err) {
+		t.Fatalf("truncated batch: want protocol error, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package tron
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// 按 2 的幂分级的 []byte 池，最小 64B，最大 4MB，更大的直接分配
+const (
+	minPoolShift = 6
+	maxPoolShift = 22
+)
+
+var bufPools [maxPoolShift - minPoolShift + 1]sync.Pool
+
+// 容量能容纳 n 字节的最小分级
+func poolClass(n int) int {
+	if n <= 1<<minPoolShift {
+		return 0
+	}
+	return bits.Len(uint(n-1)) - minPoolShift
+}
+
+// 取出长度为 n 的 buffer，内容未清零
+func getBuf(n int) []byte {
+	class := poolClass(n)
+	if class >= len(bufPools) {
+		return make([]byte, n)
+	}
+	if bp, ok := bufPools[class].Get().(*[]byte); ok {
+		return (*bp)[:n]
+	}
+	return make([]byte, n, 1<<(class+minPoolShift))
+}
+
+// 归还 buffer，调用后不可再使用 b
+func putBuf(b []byte) {
+	c := cap(b)
+	if c < 1<<minPoolShift || c&(c-1) != 0 { // 非池中分配的 buffer
+		return
+	}
+	class := poolClass(c)
+	if class >= len(bufPools) {
+		return
+	}
+	b = b[:0]
+	bufPools[class].Put(&b)
+}
@@ -0,0 +1,51 @@
+package tron
+
+import (
+	"errors"
+	"fmt"
+	"logx"
+	"sync/atomic"
+)
+
+// 包校验和不匹配时的处理方式
+type ChecksumPolicy int
+
+const (
+	CHECKSUM_DROP  ChecksumPolicy = iota // 丢弃该包，继续读取后续的包
+	CHECKSUM_CLOSE                       // 关闭会话
+)
+
+func (c ChecksumPolicy) String() string {
+	switch c {
+	case CHECKSUM_DROP:
+		return "drop"
+	case CHECKSUM_CLOSE:
+		return "close"
+	}
+	return fmt.Sprintf("ChecksumPolicy(%d)", int(c))
+}
+
+func ParseChecksumPolicy(s string) (ChecksumPolicy, error) {
+	for _, c := range []ChecksumPolicy{CHECKSUM_DROP, CHECKSUM_CLOSE} {
+		if c.String() == s {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown checksum policy %q", s)
+}
+
+// 校验失败的包数
+func (s *Session) ChecksumErrors() uint64 {
+	return atomic.LoadUint64(&s.crcErrors)
+}
+
+// 解包出错时调用，校验和不匹配且策略为丢弃时返回 true，其余错误需关闭会话
+// 长度前缀完好，丢弃后仍可正确读取下一个包
+func (s *Session) dropCorrupted(err error) bool {
+	if !errors.Is(err, ERR_CHECKSUM) {
+		return false
+	}
+	atomic.AddUint64(&s.crcErrors, 1)
+	logx.Error("%s -> %s %v", s.LocalAddr(), s.RemoteAddr(), err)
+	return s.conf.ChecksumPolicy == CHECKSUM_DROP
+}
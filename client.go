@@ -1,31 +1,74 @@
 package tron
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"logx"
 	"net"
+	"sync"
 	"time"
 )
 
+var ERR_RECONNECT_UNSUPPORTED = errors.New("reconnect unsupported")
+
 type Client struct {
-	conn      *net.TCPConn                 // 原生连接
+	conn      net.Conn                     // 原生连接
 	session   *Session                     // 连接会话
 	heartbeat int64                        // 最后心跳时间
 	handler   func(cli *Client, p *Packet) // 包处理函数
 	conf      *Config                      // 共享配置
 	codec     Codec
+	dialer    *dialer // Dial 创建的 client 按原参数重连
+	onClose   func(c *Client)
+
+	streams       map[int32]*Stream // 进行中的流
+	streamLock    sync.Mutex
+	streamHandler func(st *Stream) // 处理对端打开的流，nil 时拒绝
+	worker        bool             // server 接受的连接，不能打开流
+}
+
+func NewClient(conn net.Conn, conf *Config, workerCodec Codec, f func(cli *Client, p *Packet)) *Client {
+	cli := newClient(conn, conf, workerCodec, f)
+	cli.session = cli.newSession(conn)
+	return cli
 }
 
-func NewClient(conn *net.TCPConn, conf *Config, workerCodec Codec, f func(cli *Client, p *Packet)) *Client {
-	session := NewSession(conn, conf, workerCodec)
-	cli := &Client{
+// 事件循环模式的 client，由 poller 读包后直接分发，不启动常驻的读写协程
+func newEventClient(conn net.Conn, conf *Config, workerCodec Codec, f func(cli *Client, p *Packet)) *Client {
+	cli := newClient(conn, conf, workerCodec, f)
+	cli.session = cli.watch(newDirectSession(conn, conf, workerCodec))
+	return cli
+}
+
+func newClient(conn net.Conn, conf *Config, workerCodec Codec, f func(cli *Client, p *Packet)) *Client {
+	return &Client{
 		conn:      conn,
 		heartbeat: time.Now().Unix(),
-		session:   session,
 		handler:   f,
 		conf:      conf,
 		codec:     workerCodec,
 	}
-	return cli
+}
+
+func (c *Client) newSession(conn net.Conn) *Session {
+	return c.watch(NewSession(conn, c.conf, c.codec))
+}
+
+func (c *Client) watch(session *Session) *Session {
+	session.onClose = func() {
+		c.failStreams(ERR_STREAM_CLOSED)
+		if c.onClose != nil {
+			c.onClose(c)
+		}
+	}
+	return session
+}
+
+// 连接会话关闭时回调，需在 ReadWriteAndHandle 前设置
+func (c *Client) OnClose(f func(c *Client)) {
+	c.onClose = f
 }
 
 // 从连接中读数据，处理包，写回数据
@@ -40,27 +83,49 @@ func (c *Client) ReadWriteAndHandle() {
 
 // 异步写
 func (c *Client) AsyncWrite(p *Packet) (chan interface{}, error) {
-	if p.Header.Seq >= 0 {
-		return nil, c.session.Write(p) // worker 的响应直接写回
+	return c.AsyncWritePriority(p, PRIORITY_NORMAL)
+}
+
+// 以指定优先级异步写，高优先级的包不会排在积压的 bulk 数据之后
+func (c *Client) AsyncWritePriority(p *Packet, prio Priority) (chan interface{}, error) {
+	if !prio.valid() {
+		return nil, ERR_INVALID_PRIORITY
+	}
+	if p.Header.Seq >= 0 || p.OneWay() {
+		return nil, c.session.WritePriority(p, prio) // worker 的响应与单向消息直接写出
 	}
 
 	// 请求的 packet 将 seq 写入
 	p.Header.Seq = c.conf.SeqManager.NextSeq()
 	respCh := make(chan interface{}, 1)
 	c.conf.SeqManager.AddSeq(p.Header.Seq, respCh)
-	return respCh, c.session.Write(p)
+	if err := c.session.WritePriority(p, prio); err != nil {
+		c.conf.SeqManager.DropSeq(p.Header.Seq, respCh) // 不会有响应
+		return nil, err
+	}
+	return respCh, nil
+}
+
+// 发送单向消息，不分配 seq 也不等待响应，走 normal 队列
+// 需指定优先级时使用 AsyncWritePriority(NewOneWayPacket(data), prio)
+func (c *Client) Notify(data []byte) error {
+	return c.session.WritePriority(NewOneWayPacket(data), PRIORITY_NORMAL)
 }
 
 // 同步写
 func (c *Client) SyncWrite(newPack *Packet, timeout time.Duration) (interface{}, error) {
 	respCh, err := c.AsyncWrite(newPack)
-	if err != nil {
+	if err != nil || respCh == nil { // 单向消息无响应
 		return nil, err
 	}
 	select {
 	case <-time.After(timeout):
+		c.conf.SeqManager.DropSeq(newPack.Header.Seq, respCh) // 超时后到达的响应直接丢弃
 		return nil, fmt.Errorf("sync write: %.fs timeout", timeout.Seconds())
 	case resp := <-respCh:
+		if err, ok := resp.(error); ok { // 如 ERR_OVERLOAD
+			return nil, err
+		}
 		return resp, nil
 	}
 }
@@ -72,12 +137,35 @@ func (c *Client) NotifyReceived(seq int32, resp interface{}) {
 
 // 分发处理收取到的包
 func (c *Client) handle() {
-	for c.session != nil && !c.session.IsClosed() {
-		if p, ok := <-c.session.ReadCh; ok {
-			if c.handler != nil {
-				go c.handler(c, p)
-			}
+	for p := range c.session.ReadCh { // 会话关闭后 ReadCh 随之关闭
+		c.dispatch(p)
+	}
+}
+
+func (c *Client) dispatch(p *Packet) {
+	if p.Header.Flags&FLAG_STREAM != 0 {
+		c.dispatchStream(p)
+		return
+	}
+	switch p.Header.Seq {
+	case SEQ_BUSY: // server 即将关闭连接
+		logx.Error("%s -> %s: %s", c.LocalAddr(), c.RemoteAddr(), p.Data)
+		p.Release()
+		return
+	case SEQ_OVERLOAD: // 请求被限速丢弃，直接以错误结束等待
+		if len(p.Data) == 4 {
+			c.NotifyReceived(int32(binary.BigEndian.Uint32(p.Data)), ERR_OVERLOAD)
 		}
+		p.Release()
+		return
+	}
+	if p.Header.Seq < 0 && !p.OneWay() { // 其余保留 seq 既非请求也非响应，如对端原样回显的控制包
+		logx.Error("%s -> %s: unexpected packet with reserved seq %d", c.LocalAddr(), c.RemoteAddr(), p.Header.Seq)
+		p.Release()
+		return
+	}
+	if c.handler != nil { // 包交由 handler，可在处理完后调用 p.Release 归还 buffer
+		go c.handler(c, p)
 	}
 }
 
@@ -89,19 +177,44 @@ func (c *Client) RemoteAddr() string {
 	return c.session.RemoteAddr()
 }
 
+// 校验和不匹配的包数
+func (c *Client) ChecksumErrors() uint64 {
+	return c.session.ChecksumErrors()
+}
+
+// 关闭连接会话，不会触发重连
+func (c *Client) Close() error {
+	return c.session.Close()
+}
+
 func (c *Client) IsClosed() bool {
 	return c.session.IsClosed()
 }
 
 // 尝试重连
 func (c *Client) reconnect() (bool, error) {
-	newConn, err := net.DialTCP("tcp4", nil, c.conn.RemoteAddr().(*net.TCPAddr))
+	newConn, err := c.redial()
 	if err != nil {
 		return false, err
 	}
 
 	c.conn = newConn
-	c.session = NewSession(newConn, c.conf, c.codec) // 建立连接
-	c.ReadWriteAndHandle()                           // 重启
+	c.session = c.newSession(newConn) // 建立连接
+	if c.dialer != nil && c.conf.wantFlags() {
+		c.session.offer() // 新连接需重新协商
+	}
+	c.ReadWriteAndHandle() // 重启
 	return true, nil
 }
+
+func (c *Client) redial() (net.Conn, error) {
+	if c.dialer != nil {
+		return c.dialer.dial(context.Background())
+	}
+
+	tcpAddr, ok := c.conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, ERR_RECONNECT_UNSUPPORTED // 内存管道等连接无法重新拨号
+	}
+	return net.DialTCP("tcp4", nil, tcpAddr)
+}
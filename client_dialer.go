@@ -0,0 +1,232 @@
+package tron
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// 拨号参数，首次连接与重连共用
+type dialOptions struct {
+	network     string
+	timeout     time.Duration
+	localAddr   string
+	keepAlive   time.Duration
+	noDelay     bool
+	tlsConf     *tls.Config
+	dialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	wrapper     func(conn net.Conn) net.Conn
+	conf        *Config
+	codec       Codec
+	handler     func(cli *Client, p *Packet)
+}
+
+type DialOption func(o *dialOptions)
+
+// 拨号使用的网络类型，默认 tcp4
+func WithNetwork(network string) DialOption {
+	return func(o *dialOptions) {
+		o.network = network
+	}
+}
+
+// 单次拨号（含 TLS 握手）的超时时间
+func WithDialTimeout(d time.Duration) DialOption {
+	return func(o *dialOptions) {
+		o.timeout = d
+	}
+}
+
+// 绑定本地地址，如 "10.0.0.2:0"
+func WithLocalAddr(addr string) DialOption {
+	return func(o *dialOptions) {
+		o.localAddr = addr
+	}
+}
+
+// TCP keepalive 探测间隔，负数表示关闭
+func WithKeepAlive(d time.Duration) DialOption {
+	return func(o *dialOptions) {
+		o.keepAlive = d
+	}
+}
+
+// 是否关闭 Nagle 算法，默认关闭
+func WithNoDelay(noDelay bool) DialOption {
+	return func(o *dialOptions) {
+		o.noDelay = noDelay
+	}
+}
+
+// 建立连接后进行 TLS 握手
+func WithTLS(conf *tls.Config) DialOption {
+	return func(o *dialOptions) {
+		o.tlsConf = conf
+	}
+}
+
+// 替换底层拨号方式，如连接到 LoopbackListener
+func WithDialContext(f func(ctx context.Context, network, addr string) (net.Conn, error)) DialOption {
+	return func(o *dialOptions) {
+		o.dialContext = f
+	}
+}
+
+// 包装新建立的连接，如注入故障的 FaultConn
+func WithConnWrapper(f func(conn net.Conn) net.Conn) DialOption {
+	return func(o *dialOptions) {
+		o.wrapper = f
+	}
+}
+
+func WithClientConfig(conf *Config) DialOption {
+	return func(o *dialOptions) {
+		o.conf = conf
+	}
+}
+
+func WithClientCodec(codec Codec) DialOption {
+	return func(o *dialOptions) {
+		o.codec = codec
+	}
+}
+
+func WithClientHandler(f func(cli *Client, p *Packet)) DialOption {
+	return func(o *dialOptions) {
+		o.handler = f
+	}
+}
+
+func defaultDialOptions() dialOptions {
+	return dialOptions{
+		network:   "tcp4",
+		timeout:   5 * time.Second,
+		keepAlive: 15 * time.Second,
+		noDelay:   true,
+	}
+}
+
+func (o *dialOptions) validate(addr string) error {
+	errs := &ConfigError{}
+	if addr == "" {
+		errs.Addf("dial address is empty")
+	}
+	if o.network == "" {
+		errs.Addf("dial network is empty")
+	}
+	if o.timeout < 0 {
+		errs.Addf("dial timeout must not be negative, got %v", o.timeout)
+	}
+	if o.localAddr != "" && o.dialContext != nil {
+		errs.Addf("local address %q is ignored by a custom dial function", o.localAddr)
+	}
+	errs.Merge(o.conf.Validate())
+	validateFragmentSize(errs, o.conf, o.codec)
+	return errs.Err()
+}
+
+// 负责建立连接，client 重连时按相同参数重新拨号
+type dialer struct {
+	addr string
+	opts dialOptions
+}
+
+func (d *dialer) dial(ctx context.Context) (net.Conn, error) {
+	if d.opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.opts.timeout)
+		defer cancel()
+	}
+
+	conn, err := d.dialRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := tcpConn.SetNoDelay(d.opts.noDelay); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if d.opts.tlsConf != nil {
+		tlsConn := tls.Client(conn, d.tlsConfig())
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	if d.opts.wrapper != nil {
+		conn = d.opts.wrapper(conn)
+	}
+	return conn, nil
+}
+
+func (d *dialer) dialRaw(ctx context.Context) (net.Conn, error) {
+	if d.opts.dialContext != nil {
+		return d.opts.dialContext(ctx, d.opts.network, d.addr)
+	}
+
+	nd := &net.Dialer{KeepAlive: d.opts.keepAlive}
+	if d.opts.localAddr != "" {
+		lAddr, err := net.ResolveTCPAddr(d.opts.network, d.opts.localAddr)
+		if err != nil {
+			return nil, err
+		}
+		nd.LocalAddr = lAddr
+	}
+	return nd.DialContext(ctx, d.opts.network, d.addr)
+}
+
+// 未指定 ServerName 时使用拨号地址的 host
+func (d *dialer) tlsConfig() *tls.Config {
+	if d.opts.tlsConf.ServerName != "" || d.opts.tlsConf.InsecureSkipVerify {
+		return d.opts.tlsConf
+	}
+	conf := d.opts.tlsConf.Clone()
+	if host, _, err := net.SplitHostPort(d.addr); err == nil {
+		conf.ServerName = host
+	}
+	return conf
+}
+
+// 拨号并启动 client 的读写与处理流程
+func Dial(ctx context.Context, addr string, opts ...DialOption) (*Client, error) {
+	o := defaultDialOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.conf == nil {
+		o.conf = NewDefaultConf(1 * time.Minute)
+	}
+	if o.codec == nil {
+		o.codec = NewDefaultCodec()
+	}
+	if err := o.validate(addr); err != nil {
+		return nil, err
+	}
+
+	d := &dialer{addr: addr, opts: o}
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cli := NewClient(conn, o.conf, o.codec, o.handler)
+	cli.dialer = d
+	if cli.conf.wantFlags() {
+		cli.session.offer() // 先于其他请求发出
+	}
+	cli.ReadWriteAndHandle()
+	if cli.conf.Batch { // 超时视为旧版本 server，批量请求逐个发送
+		if err := cli.session.negotiate(ctx); err == context.Canceled {
+			cli.Close()
+			return nil, err
+		}
+	}
+	return cli, nil
+}
@@ -0,0 +1,87 @@
+package tron
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 重连按 Dial 时的参数重新拨号，配置、codec 与 handler 不变
+func TestReconnectReusesDialOptions(t *testing.T) {
+	l := NewLoopbackListener("dialer")
+	defer l.Close()
+	s, err := NewServerWith(l.Addr().String(), WithServerHandler(func(worker *Client, p *Packet) {
+		worker.AsyncWrite(NewRespPacket(p.Header.Seq, p.Data))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(l); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+
+	conf, err := BuildConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := NewDefaultCodec()
+	var dials, wraps int32
+	var dialed atomic.Value
+	cli, err := Dial(context.Background(), "svc:9000",
+		WithClientConfig(conf),
+		WithClientCodec(codec),
+		WithClientHandler(func(cli *Client, p *Packet) {
+			cli.NotifyReceived(p.Header.Seq, p.Data)
+		}),
+		WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			dialed.Store(network + "/" + addr)
+			return l.Dial()
+		}),
+		WithConnWrapper(func(conn net.Conn) net.Conn {
+			atomic.AddInt32(&wraps, 1)
+			return conn
+		}),
+	)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer cli.Close()
+
+	ping := func() {
+		resp, err := cli.SyncWrite(NewReqPacket([]byte("ping")), time.Second)
+		if err != nil || string(resp.([]byte)) != "ping" {
+			t.Fatalf("ping: %v %v", resp, err)
+		}
+	}
+	ping()
+	cli.Close()
+	if ok, err := cli.reconnect(); !ok || err != nil {
+		t.Fatalf("reconnect failed: %v", err)
+	}
+	ping()
+
+	if d, w := atomic.LoadInt32(&dials), atomic.LoadInt32(&wraps); d != 2 || w != 2 {
+		t.Fatalf("want 2 dials and 2 wraps, got %d and %d", d, w)
+	}
+	if got := dialed.Load(); got != "tcp4/svc:9000" {
+		t.Fatalf("reconnect dialed %v", got)
+	}
+	if cli.conf != conf || cli.codec != codec {
+		t.Fatal("reconnect replaced the config or codec")
+	}
+}
+
+// 非 Dial 创建且无法重新拨号的 client 返回 ERR_RECONNECT_UNSUPPORTED
+func TestReconnectUnsupported(t *testing.T) {
+	c, peer := net.Pipe()
+	defer peer.Close()
+	cli := NewClient(c, NewDefaultConf(time.Minute), NewDefaultCodec(), nil)
+	cli.Close()
+	if ok, err := cli.reconnect(); ok || err != ERR_RECONNECT_UNSUPPORTED {
+		t.Fatalf("want ERR_RECONNECT_UNSUPPORTED, got %v %v", ok, err)
+	}
+}
@@ -32,17 +32,14 @@ func (t *reconnectTask) connect() bool {
 }
 
 type ReconnectTaskManager struct {
-	tw       *timewheel.TimeWheel // 任务时间轮
-	timeout  time.Duration        // 初次尝试重连的超时时间
-	maxRetry int                  // 最大尝试次数
-	retrying map[string]bool      // 正在重连
-	lock     sync.Mutex
+	tw          *timewheel.TimeWheel // 任务时间轮
+	timeout     time.Duration        // 初次尝试重连的超时时间
+	maxRetry    int                  // 最大尝试次数
+	retryPoints []int64              // 各次重连相对 timeout 的倍数
+	retrying    map[string]bool      // 正在重连
+	lock        sync.Mutex
 }
 
-var (
-	retryPoints []int64
-)
-
 func NewReconnectTaskManager(timeout time.Duration, maxRetry int) *ReconnectTaskManager {
 	m := &ReconnectTaskManager{
 		tw:       timewheel.NewTimeWheel(10*time.Millisecond, 6000),
@@ -51,24 +48,24 @@ func NewReconnectTaskManager(timeout time.Duration, maxRetry int) *ReconnectTask
 		retrying: make(map[string]bool),
 	}
 	for i := 0; i < maxRetry; i++ {
-		retryPoints = append(retryPoints, int64(math.Pow(2, float64(i)))) // 二次规避策略
+		m.retryPoints = append(m.retryPoints, int64(math.Pow(2, float64(i)))) // 二次规避策略
 	}
 
 	return m
 }
 
 // 准备重连任务
-func (m ReconnectTaskManager) reconnect(cli *Client) {
+func (m *ReconnectTaskManager) reconnect(cli *Client) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	if _, ok := m.retrying[cli.RemoteAddr()]; ok { // 已经在尝试重连了...
+	addr := cli.RemoteAddr()
+	if _, ok := m.retrying[addr]; ok { // 已经在尝试重连了...
 		return
 	}
 
-	newTask := newReconnectTask(cli)
-	go m.exec(newTask)
-	m.retrying[cli.RemoteAddr()] = true
+	m.retrying[addr] = true
+	go m.exec(newReconnectTask(cli))
 }
 
 var (
@@ -83,8 +80,8 @@ func (m *ReconnectTaskManager) exec(task *reconnectTask) {
 	sAddr := SplitPort(serverAddr)
 	lAddr := SplitPort(task.client.LocalAddr())
 
-	fmt.Printf("%v %v\n", m.timeout, retryPoints)
-	tids, resChs := m.tw.AfterPoints(m.timeout, retryPoints, func() interface{} {
+	fmt.Printf("%v %v\n", m.timeout, m.retryPoints)
+	tids, resChs := m.tw.AfterPoints(m.timeout, m.retryPoints, func() interface{} {
 		if succ := task.connect(); !succ {
 			if task.retried >= m.maxRetry {
 				fmt.Printf("[client:%s] -> [server:%s] over tried %d times\n", lAddr, sAddr, task.retried)
@@ -114,10 +111,24 @@ func (m *ReconnectTaskManager) exec(task *reconnectTask) {
 			for _, tid := range tids {
 				m.tw.Cancel(tid) // 取消剩余任务
 			}
-			delete(m.retrying, task.client.RemoteAddr())
+			m.done(serverAddr)
 		case STATUS_OVERTRIED:
-			delete(m.retrying, task.client.RemoteAddr())
+			m.done(serverAddr)
 		}
 	}
 	close(doneCh)
 }
+
+// 重连结束，重连成功后 client 的会话已替换，按发起时的地址删除
+func (m *ReconnectTaskManager) done(addr string) {
+	m.lock.Lock()
+	delete(m.retrying, addr)
+	m.lock.Unlock()
+}
+
+// 是否正在重连
+func (m *ReconnectTaskManager) isRetrying(addr string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.retrying[addr]
+}
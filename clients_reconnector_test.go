@@ -0,0 +1,75 @@
+package tron
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 注入的连接重置后，ReconnectTaskManager 重新拨号，client 继续收到响应
+func TestReconnectAfterFaultReset(t *testing.T) {
+	l := NewLoopbackListener("reconnect")
+	defer l.Close()
+	s, err := NewServerWith(l.Addr().String(), WithServerHandler(func(worker *Client, p *Packet) {
+		worker.AsyncWrite(NewRespPacket(p.Header.Seq, p.Data))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(l); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+
+	var conns int32
+	cli, err := Dial(context.Background(), l.Addr().String(),
+		WithClientHandler(func(cli *Client, p *Packet) {
+			cli.NotifyReceived(p.Header.Seq, p.Data)
+		}),
+		WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return l.Dial()
+		}),
+		WithConnWrapper(func(conn net.Conn) net.Conn {
+			if atomic.AddInt32(&conns, 1) == 1 { // 仅首个连接在写入时重置
+				return NewFaultConn(conn, FaultConfig{ResetAfter: 1})
+			}
+			return conn
+		}),
+	)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer cli.Close()
+
+	if _, err := cli.SyncWrite(NewReqPacket([]byte("ping")), 100*time.Millisecond); err == nil {
+		t.Fatal("sync write should fail after reset")
+	}
+	deadline := time.Now().Add(time.Second)
+	for !cli.IsClosed() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !cli.IsClosed() {
+		t.Fatal("client not closed after reset")
+	}
+
+	addr := cli.RemoteAddr()
+	m := NewReconnectTaskManager(10*time.Millisecond, 1)
+	m.reconnect(cli)
+	deadline = time.Now().Add(2 * time.Second)
+	for m.isRetrying(addr) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if m.isRetrying(addr) {
+		t.Fatal("reconnect did not finish")
+	}
+
+	resp, err := cli.SyncWrite(NewReqPacket([]byte("ping")), time.Second)
+	if err != nil || string(resp.([]byte)) != "ping" {
+		t.Fatalf("after reconnect: %v %v", resp, err)
+	}
+	if n := atomic.LoadInt32(&conns); n != 2 {
+		t.Fatalf("want 2 connections, got %d", n)
+	}
+}
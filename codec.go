@@ -15,3 +15,15 @@ type Codec interface {
 	// 拼包
 	MarshalPacket(p Packet) []byte
 }
+
+// 可选实现：MarshalPacket 返回池中的 buffer，写入连接后由 session 归还
+type bufReleaser interface {
+	ReleaseBuf(b []byte)
+}
+
+// 可选实现：分别编码包头与包尾，包数据在两者之间原样写出
+// session 借此以 writev 发送包数据，无需拷贝到写缓冲
+type headerAppender interface {
+	AppendPacketHeader(dst []byte, p Packet) []byte
+	AppendPacketTrailer(dst []byte, p Packet) []byte
+}
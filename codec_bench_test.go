@@ -0,0 +1,41 @@
+package tron
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// 一次完整的拼包、写入、读取、解包往返
+func benchmarkRoundTrip(b *testing.B, size int) {
+	codec := NewDefaultCodec()
+	data := bytes.Repeat([]byte("x"), size)
+	var conn bytes.Buffer
+	r := bufio.NewReaderSize(&conn, 64*1024)
+	w := bufio.NewWriterSize(&conn, 64*1024)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := NewRespPacket(int32(i), data)
+		buf := codec.MarshalPacket(*p)
+		w.Write(buf)
+		w.Flush()
+		codec.ReleaseBuf(buf)
+
+		frame, err := codec.ReadPacket(r)
+		if err != nil {
+			b.Fatal(err)
+		}
+		p, err = codec.UnmarshalPacket(frame)
+		if err != nil {
+			b.Fatal(err)
+		}
+		p.Release()
+	}
+}
+
+func BenchmarkRoundTrip64(b *testing.B)  { benchmarkRoundTrip(b, 64) }
+func BenchmarkRoundTrip1K(b *testing.B)  { benchmarkRoundTrip(b, 1024) }
+func BenchmarkRoundTrip64K(b *testing.B) { benchmarkRoundTrip(b, 64*1024) }
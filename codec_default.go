@@ -3,53 +3,153 @@ package tron
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
 )
 
+const DEFAULT_MAX_PACKET_LEN = 4 * 1024 * 1024 // 默认单个包最大 4MB
+
+var (
+	ERR_PACKET_TOO_LARGE   = errors.New("packet too large")
+	ERR_PACKET_LEN_INVALID = errors.New("invalid packet length")
+	ERR_CHECKSUM           = errors.New("checksum mismatch")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// 对端发送了不合法的数据，只关闭出错的会话
+type ProtocolError struct {
+	Err error
+}
+
+func (e *ProtocolError) Error() string {
+	return "protocol error: " + e.Err.Error()
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+func IsProtocolError(err error) bool {
+	var pe *ProtocolError
+	return errors.As(err, &pe)
+}
+
 // 默认 codec
-type DefaultCodec struct{}
+type DefaultCodec struct {
+	maxPacketLen int32 // 长度前缀允许的最大值
+}
+
+type CodecOption func(c *DefaultCodec)
+
+// 单个包（不含 4 字节长度前缀）的最大长度，<= 0 则使用默认值
+func WithMaxPacketLen(n int32) CodecOption {
+	return func(c *DefaultCodec) {
+		if n > 0 {
+			c.maxPacketLen = n
+		}
+	}
+}
 
-func NewDefaultCodec() *DefaultCodec {
-	return &DefaultCodec{}
+func NewDefaultCodec(opts ...CodecOption) *DefaultCodec {
+	c := &DefaultCodec{
+		maxPacketLen: DEFAULT_MAX_PACKET_LEN,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
+// 读取一个完整的包（不含长度前缀），返回的 buffer 来自池中
 func (c *DefaultCodec) ReadPacket(r *bufio.Reader) ([]byte, error) {
-	var packLen int32
-	err := read(r, binary.BigEndian, &packLen)
+	prefix, err := r.Peek(PACK_LEN)
 	if err != nil {
+		if err == io.EOF && len(prefix) > 0 { // 长度前缀不完整
+			err = io.ErrUnexpectedEOF
+		}
 		return nil, err
 	}
+	packLen := int32(binary.BigEndian.Uint32(prefix))
+	r.Discard(PACK_LEN)
 
-	buf := make([]byte, packLen)
-	curLen := 0
-	for {
-		n, err := r.Read(buf)
-		if err != nil {
-			return nil, err
-		}
-		curLen += n
+	// 分配内存前校验长度前缀，避免恶意包耗尽内存
+	if packLen < HEADER_LEN {
+		return nil, &ProtocolError{fmt.Errorf("%w: %d", ERR_PACKET_LEN_INVALID, packLen)}
+	}
+	if packLen > c.maxPacketLen {
+		return nil, &ProtocolError{fmt.Errorf("%w: %d > %d", ERR_PACKET_TOO_LARGE, packLen, c.maxPacketLen)}
+	}
 
-		if int32(curLen) < packLen { // 未读完
-			continue
-		} else {
-			buf = buf[:curLen]
-			break
+	// 包可能被拆分到多次读取中，读满为止
+	buf := getBuf(int(packLen))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		putBuf(buf)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF // 已读到长度前缀，包体不完整
 		}
+		return nil, err
 	}
 	return buf, nil
 }
 
+// 拼包使用池中的 buffer，写入连接后由 session 调用 ReleaseBuf 归还
 func (c *DefaultCodec) MarshalPacket(p Packet) []byte {
-	hData := MarshalHeader(p.Header)
-	return append(hData, p.Data...)
+	buf := getBuf(PACK_LEN + p.Header.Len() + len(p.Data) + p.Header.TrailerLen())[:0]
+	buf = c.AppendPacketHeader(buf, p)
+	buf = append(buf, p.Data...)
+	return c.AppendPacketTrailer(buf, p)
+}
+
+// 追加包头，DataLen 以实际数据长度为准
+func (c *DefaultCodec) AppendPacketHeader(dst []byte, p Packet) []byte {
+	h := *p.Header
+	h.DataLen = int32(len(p.Data))
+	return AppendHeader(dst, &h)
 }
 
+// 追加数据之后的校验和，覆盖头部与数据
+func (c *DefaultCodec) AppendPacketTrailer(dst []byte, p Packet) []byte {
+	if p.Header.Flags&FLAG_CRC == 0 {
+		return dst
+	}
+	h := *p.Header
+	h.DataLen = int32(len(p.Data))
+	var hdr [PACK_LEN + HEADER_LEN + FLAGS_LEN]byte
+	sum := crc32.Update(crc32.Checksum(AppendHeader(hdr[:0], &h)[PACK_LEN:], crcTable), crcTable, p.Data)
+	var b [CRC_LEN]byte
+	binary.BigEndian.PutUint32(b[:], sum)
+	return append(dst, b[:]...)
+}
+
+// 归还 MarshalPacket 返回的 buffer
+func (c *DefaultCodec) ReleaseBuf(b []byte) {
+	putBuf(b)
+}
+
+// 零拷贝解包，Packet.Data 直接引用 b，b 归 Packet 所有，由 Packet.Release 归还
+// 解包失败时 b 立即归还到池中
 func (c *DefaultCodec) UnmarshalPacket(b []byte) (*Packet, error) {
 	h, err := UnmarshalHeader(b)
 	if err != nil {
-		return nil, err
+		putBuf(b)
+		return nil, &ProtocolError{err}
+	}
+	end := h.Len() + int(h.DataLen)
+	if end > len(b)-h.TrailerLen() {
+		putBuf(b)
+		return nil, &ProtocolError{fmt.Errorf("%w: data length %d exceeds packet length %d", ERR_PACKET_LEN_INVALID, h.DataLen, len(b))}
+	}
+	if h.Flags&FLAG_CRC != 0 {
+		want := binary.BigEndian.Uint32(b[end : end+CRC_LEN])
+		if got := crc32.Checksum(b[:end], crcTable); got != want {
+			putBuf(b)
+			return nil, &ProtocolError{fmt.Errorf("%w: got %#x, want %#x", ERR_CHECKSUM, got, want)}
+		}
 	}
 
-	data := b[HEADER_LEN : HEADER_LEN+h.DataLen]
-	h.DataLen = int32(len(data))
-	return &Packet{Header: h, Data: data}, nil
+	data := b[h.Len():end]
+	return &Packet{Header: h, Data: data, buf: b}, nil
 }
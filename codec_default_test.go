@@ -0,0 +1,169 @@
+package tron
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// 依次返回各个分片的 reader，模拟 TCP 将包拆分到多次读取中
+type chunkReader struct {
+	chunks [][]byte
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	for len(r.chunks) > 0 && len(r.chunks[0]) == 0 {
+		r.chunks = r.chunks[1:]
+	}
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, r.chunks[0])
+	r.chunks[0] = r.chunks[0][n:]
+	return n, nil
+}
+
+func marshalStream(codec *DefaultCodec, datas ...string) ([]byte, []*Packet) {
+	var stream []byte
+	var packs []*Packet
+	for i, data := range datas {
+		p := NewRespPacket(int32(i), []byte(data))
+		packs = append(packs, p)
+		stream = append(stream, codec.MarshalPacket(*p)...)
+	}
+	return stream, packs
+}
+
+func readAll(t *testing.T, codec *DefaultCodec, r io.Reader, want []*Packet) {
+	br := bufio.NewReaderSize(r, 16) // 小于包长，迫使包体跨越多次填充
+	for i, w := range want {
+		b, err := codec.ReadPacket(br)
+		if err != nil {
+			t.Fatalf("packet %d: read failed: %v", i, err)
+		}
+		p, err := codec.UnmarshalPacket(b)
+		if err != nil {
+			t.Fatalf("packet %d: unmarshal failed: %v", i, err)
+		}
+		if p.Header.Seq != w.Header.Seq || !bytes.Equal(p.Data, w.Data) {
+			t.Fatalf("packet %d: got seq %d data %q, want seq %d data %q", i, p.Header.Seq, p.Data, w.Header.Seq, w.Data)
+		}
+		p.Release()
+	}
+	if _, err := codec.ReadPacket(br); err != io.EOF {
+		t.Fatalf("expect EOF after all packets, got %v", err)
+	}
+}
+
+func TestReadPacketSplitAtEveryByte(t *testing.T) {
+	codec := NewDefaultCodec()
+	stream, packs := marshalStream(codec, "ping", "", "a longer payload spanning buffer fills", "pong")
+
+	for i := 0; i <= len(stream); i++ {
+		t.Run(fmt.Sprintf("split-%d", i), func(t *testing.T) {
+			r := &chunkReader{chunks: [][]byte{
+				append([]byte(nil), stream[:i]...),
+				append([]byte(nil), stream[i:]...),
+			}}
+			readAll(t, codec, r, packs)
+		})
+	}
+}
+
+func TestReadPacketOneByteReads(t *testing.T) {
+	codec := NewDefaultCodec()
+	stream, packs := marshalStream(codec, "ping", "pong", "0123456789abcdefghijklmnopqrstuvwxyz")
+	readAll(t, codec, iotest.OneByteReader(bytes.NewReader(stream)), packs)
+}
+
+func TestReadPacketTruncated(t *testing.T) {
+	codec := NewDefaultCodec()
+	stream, _ := marshalStream(codec, "ping")
+	for i := 1; i < len(stream); i++ {
+		_, err := codec.ReadPacket(bufio.NewReader(bytes.NewReader(stream[:i])))
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("truncated at %d: want io.ErrUnexpectedEOF, got %v", i, err)
+		}
+	}
+}
+
+func TestReadPacketInvalidLen(t *testing.T) {
+	codec := NewDefaultCodec(WithMaxPacketLen(64))
+	for _, packLen := range []int32{-1, 0, HEADER_LEN - 1, 65, 1 << 30} {
+		prefix := make([]byte, PACK_LEN)
+		binary.BigEndian.PutUint32(prefix, uint32(packLen))
+		_, err := codec.ReadPacket(bufio.NewReader(bytes.NewReader(prefix)))
+		if !IsProtocolError(err) {
+			t.Fatalf("packet len %d: want protocol error, got %v", packLen, err)
+		}
+	}
+}
+
+func TestUnmarshalPacketBounds(t *testing.T) {
+	codec := NewDefaultCodec()
+	b := codec.MarshalPacket(*NewRespPacket(1, []byte("data")))[PACK_LEN:]
+
+	// 截断的包与伪造的 dataLen 都应返回错误而非 panic
+	for i := 0; i < len(b); i++ {
+		if _, err := codec.UnmarshalPacket(b[:i]); !IsProtocolError(err) {
+			t.Fatalf("truncated at %d: want protocol error, got %v", i, err)
+		}
+	}
+	for _, dataLen := range []int32{-1, 5, 1 << 30} {
+		forged := append([]byte(nil), b...)
+		binary.BigEndian.PutUint32(forged[4:8], uint32(dataLen))
+		if _, err := codec.UnmarshalPacket(forged); !IsProtocolError(err) {
+			t.Fatalf("data len %d: want protocol error, got %v", dataLen, err)
+		}
+	}
+}
+
+func TestHeaderFlagsRoundTrip(t *testing.T) {
+	codec := NewDefaultCodec()
+	p := NewRespPacket(7, []byte("data"))
+	plain := codec.MarshalPacket(*p)
+
+	p.Header.Flags = FLAG_GZIP
+	b := codec.MarshalPacket(*p)
+	if len(b) != len(plain)+FLAGS_LEN {
+		t.Fatalf("flags extension: got %d bytes, want %d", len(b), len(plain)+FLAGS_LEN)
+	}
+	got, err := codec.UnmarshalPacket(b[PACK_LEN:])
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if got.Header.Flags != FLAG_GZIP || got.Header.Seq != 7 || string(got.Data) != "data" {
+		t.Fatalf("got %+v data %q", got.Header, got.Data)
+	}
+
+	// 置位 flags 扩展却未携带 flags
+	forged := append([]byte(nil), b[PACK_LEN:]...)
+	binary.BigEndian.PutUint32(forged[HEADER_LEN:], 0)
+	if _, err := codec.UnmarshalPacket(forged); !IsProtocolError(err) {
+		t.Fatalf("empty flags: want protocol error, got %v", err)
+	}
+}
+
+func TestChecksumDetectsCorruption(t *testing.T) {
+	codec := NewDefaultCodec()
+	p := NewRespPacket(3, []byte("payload"))
+	p.Header.Flags = FLAG_CRC
+	b := codec.MarshalPacket(*p)[PACK_LEN:]
+	if got, err := codec.UnmarshalPacket(b); err != nil || string(got.Data) != "payload" {
+		t.Fatalf("intact packet: got %v, %v", got, err)
+	}
+
+	// 任一字节损坏都应被发现
+	for i := 0; i < len(b); i++ {
+		corrupted := append([]byte(nil), b...)
+		corrupted[i] ^= 0x01
+		_, err := codec.UnmarshalPacket(corrupted)
+		if err == nil {
+			t.Fatalf("corruption at %d not detected", i)
+		}
+	}
+}
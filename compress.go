@@ -0,0 +1,124 @@
+package tron
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// 包数据的压缩算法
+type Compression int
+
+const (
+	COMPRESS_NONE Compression = iota
+	COMPRESS_DEFLATE
+	COMPRESS_GZIP
+)
+
+const (
+	DEFAULT_COMPRESS_MIN = 1024             // 小于 1KB 的数据压缩收益不大
+	MAX_INFLATE_LEN      = 64 * 1024 * 1024 // 解压后的最大长度，防止压缩炸弹
+)
+
+var ERR_INFLATE_TOO_LARGE = errors.New("inflated data too large")
+
+func (c Compression) String() string {
+	switch c {
+	case COMPRESS_NONE:
+		return "none"
+	case COMPRESS_DEFLATE:
+		return "deflate"
+	case COMPRESS_GZIP:
+		return "gzip"
+	}
+	return fmt.Sprintf("Compression(%d)", int(c))
+}
+
+func ParseCompression(s string) (Compression, error) {
+	for _, c := range []Compression{COMPRESS_NONE, COMPRESS_DEFLATE, COMPRESS_GZIP} {
+		if c.String() == s {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown compression %q", s)
+}
+
+// 压缩后的包在头部标记的 flag
+func (c Compression) flag() uint32 {
+	switch c {
+	case COMPRESS_DEFLATE:
+		return FLAG_DEFLATE
+	case COMPRESS_GZIP:
+		return FLAG_GZIP
+	}
+	return 0
+}
+
+var (
+	deflaters = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+	gzippers = sync.Pool{New: func() interface{} {
+		return gzip.NewWriter(nil)
+	}}
+)
+
+// 压缩 data，压缩后未变小时返回 nil
+func compress(c Compression, data []byte) []byte {
+	var buf bytes.Buffer
+	switch c {
+	case COMPRESS_DEFLATE:
+		w := deflaters.Get().(*flate.Writer)
+		w.Reset(&buf)
+		w.Write(data)
+		w.Close()
+		deflaters.Put(w)
+	case COMPRESS_GZIP:
+		w := gzippers.Get().(*gzip.Writer)
+		w.Reset(&buf)
+		w.Write(data)
+		w.Close()
+		gzippers.Put(w)
+	default:
+		return nil
+	}
+	if buf.Len() >= len(data) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// 按 flags 中的压缩标记解压
+func decompress(flags uint32, data []byte) ([]byte, error) {
+	var r io.Reader
+	switch {
+	case flags&FLAG_DEFLATE != 0:
+		fr := flate.NewReader(bytes.NewReader(data))
+		defer fr.Close()
+		r = fr
+	case flags&FLAG_GZIP != 0:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	default:
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(r, MAX_INFLATE_LEN+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > MAX_INFLATE_LEN {
+		return nil, fmt.Errorf("%w: exceeds %d bytes", ERR_INFLATE_TOO_LARGE, MAX_INFLATE_LEN)
+	}
+	return buf.Bytes(), nil
+}
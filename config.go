@@ -1,28 +1,297 @@
 package tron
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 type Config struct {
-	ReadBufSize   int           // 读缓冲区大小
-	WriteBufSize  int           // 写缓冲区大小
-	ReadChanSize  int           // 异步读 channel 大小
-	WriteChanSize int           // 异步写 channel 大小
-	IdleDuration  time.Duration // 连接的最大空闲时间
-	SeqManager    *SeqManager   // 包序号管理
+	ReadBufSize    int            // 读缓冲区大小
+	WriteBufSize   int            // 写缓冲区大小
+	ReadChanSize   int            // 异步读 channel 大小
+	WriteChanSize  int            // 异步写 channel 大小
+	WriteBatch     int            // 单次 flush 合并的最大包数
+	WriteDelay     time.Duration  // 等待凑批的最长时间，0 则只合并已排队的包
+	Compression    Compression    // 发送数据的压缩算法，需对端协商支持
+	CompressMin    int            // 数据不小于该长度才压缩
+	Checksum       bool           // 发送的包附带 CRC32C 校验和，需对端协商支持
+	ChecksumPolicy ChecksumPolicy // 收到校验失败的包时的处理方式
+	FragmentSize   int            // 超过该长度的数据分片发送，0 则不分片
+	PartialBytes   int            // 重组中的分片消息的总字节数上限，0 则为 DEFAULT_PARTIAL_BYTES
+	Batch          bool           // 批量请求打包为一个帧发送，需对端协商支持
+	StreamWindow   int            // 单个流的接收窗口字节数
+	ConnWindow     int            // 连接上所有流的接收窗口字节数
+	MaxSeq         int32          // 最大包序号，序号轮回使用
+	IdleDuration   time.Duration  // 连接的最大空闲时间，server 端超时未读到包则关闭连接
+	SeqManager     *SeqManager    // 包序号管理
 }
 
+const (
+	DEFAULT_BUF_SIZE  = 16 * 1024
+	DEFAULT_CHAN_SIZE = 100
+	DEFAULT_BATCH     = 64
+	DEFAULT_MAX_SEQ   = 1000
+	DEFAULT_IDLE      = 1 * time.Minute
+)
+
 func NewDefaultConf(idle time.Duration) *Config {
-	return NewConfig(16*1024, 16*1024, 100, 100, 1000, idle)
+	return NewConfig(DEFAULT_BUF_SIZE, DEFAULT_BUF_SIZE, DEFAULT_CHAN_SIZE, DEFAULT_CHAN_SIZE, DEFAULT_MAX_SEQ, idle)
 }
 
+// 位置参数容易混淆且不做校验，推荐使用 BuildConfig
 func NewConfig(rBufSize, wBufSize int, rChSize, wChSize int, maxSeq int32, idle time.Duration) *Config {
 	c := &Config{
 		ReadBufSize:   rBufSize,
 		WriteBufSize:  wBufSize,
 		ReadChanSize:  rChSize,
 		WriteChanSize: wChSize,
+		WriteBatch:    DEFAULT_BATCH,
+		CompressMin:   DEFAULT_COMPRESS_MIN,
+		StreamWindow:  DEFAULT_STREAM_WINDOW,
+		ConnWindow:    DEFAULT_CONN_WINDOW,
+		MaxSeq:        maxSeq,
 		IdleDuration:  idle,
 		SeqManager:    NewSeqManager(maxSeq),
 	}
 	return c
 }
+
+type ConfigOption func(c *Config)
+
+func WithReadBufSize(n int) ConfigOption {
+	return func(c *Config) {
+		c.ReadBufSize = n
+	}
+}
+
+func WithWriteBufSize(n int) ConfigOption {
+	return func(c *Config) {
+		c.WriteBufSize = n
+	}
+}
+
+func WithReadChanSize(n int) ConfigOption {
+	return func(c *Config) {
+		c.ReadChanSize = n
+	}
+}
+
+func WithWriteChanSize(n int) ConfigOption {
+	return func(c *Config) {
+		c.WriteChanSize = n
+	}
+}
+
+// 写协程单次 flush 最多合并 n 个包，1 则逐包写入
+func WithWriteBatch(n int) ConfigOption {
+	return func(c *Config) {
+		c.WriteBatch = n
+	}
+}
+
+// 写协程凑批的最长等待时间，以延迟换取更少的系统调用
+func WithWriteDelay(d time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.WriteDelay = d
+	}
+}
+
+// 压缩发送的数据，Dial 创建的 client 建连后与 server 协商，对端不支持时不压缩
+// client 开启后 server 的响应才可能被压缩
+func WithCompression(c Compression) ConfigOption {
+	return func(conf *Config) {
+		conf.Compression = c
+	}
+}
+
+// 小于 n 字节的数据不压缩
+func WithCompressMin(n int) ConfigOption {
+	return func(c *Config) {
+		c.CompressMin = n
+	}
+}
+
+// 发送的包附带 CRC32C 校验和，与压缩相同需建连后协商
+// 协商完成后对端须在每个包上附带校验和，缺少校验和的包与校验失败的包同样处理
+func WithChecksum(on bool) ConfigOption {
+	return func(c *Config) {
+		c.Checksum = on
+	}
+}
+
+func WithChecksumPolicy(p ChecksumPolicy) ConfigOption {
+	return func(c *Config) {
+		c.ChecksumPolicy = p
+	}
+}
+
+// AsyncWriteBatch 将多个请求打包为一个帧，Dial 时等待协商完成
+// 旧版本 server 不回复协商，等待超时后逐个发送
+func WithBatch(on bool) ConfigOption {
+	return func(c *Config) {
+		c.Batch = on
+	}
+}
+
+// 数据超过 n 字节时拆为多个分片发送，与其他包交错，避免阻塞小请求
+// 需对端协商支持，接收方在 session 内重组后再交给 handler
+func WithFragmentSize(n int) ConfigOption {
+	return func(c *Config) {
+		c.FragmentSize = n
+	}
+}
+
+// 限制对端的分片消息在重组中占用的内存，超过时关闭会话
+// 单个分片消息的长度也受此限制
+func WithPartialBytes(n int) ConfigOption {
+	return func(c *Config) {
+		c.PartialBytes = n
+	}
+}
+
+// 流的接收窗口，对端在窗口耗尽后暂停该流的发送，直到本端读取了数据
+func WithStreamWindow(n int) ConfigOption {
+	return func(c *Config) {
+		c.StreamWindow = n
+	}
+}
+
+// 连接的接收窗口，限制对端所有流在途的数据总量
+func WithConnWindow(n int) ConfigOption {
+	return func(c *Config) {
+		c.ConnWindow = n
+	}
+}
+
+func WithMaxSeq(maxSeq int32) ConfigOption {
+	return func(c *Config) {
+		c.MaxSeq = maxSeq
+	}
+}
+
+func WithIdleDuration(d time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.IdleDuration = d
+	}
+}
+
+// 在默认配置上应用 opts，校验通过后创建 SeqManager
+func BuildConfig(opts ...ConfigOption) (*Config, error) {
+	c := &Config{
+		ReadBufSize:   DEFAULT_BUF_SIZE,
+		WriteBufSize:  DEFAULT_BUF_SIZE,
+		ReadChanSize:  DEFAULT_CHAN_SIZE,
+		WriteChanSize: DEFAULT_CHAN_SIZE,
+		WriteBatch:    DEFAULT_BATCH,
+		CompressMin:   DEFAULT_COMPRESS_MIN,
+		StreamWindow:  DEFAULT_STREAM_WINDOW,
+		ConnWindow:    DEFAULT_CONN_WINDOW,
+		MaxSeq:        DEFAULT_MAX_SEQ,
+		IdleDuration:  DEFAULT_IDLE,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if err := c.validate().Err(); err != nil {
+		return nil, err
+	}
+	c.SeqManager = NewSeqManager(c.MaxSeq)
+	return c, nil
+}
+
+// 校验各项配置，返回包含全部问题的 *ConfigError
+// 手动构造的 Config 需以 NewSeqManager(MaxSeq) 设置 SeqManager
+func (c *Config) Validate() error {
+	errs := c.validate()
+	if c.SeqManager == nil {
+		errs.Addf("SeqManager is nil, set it with NewSeqManager(%d)", c.MaxSeq)
+	} else if c.SeqManager.maxSeq != c.MaxSeq {
+		errs.Addf("SeqManager maxSeq %d does not match MaxSeq %d", c.SeqManager.maxSeq, c.MaxSeq)
+	}
+	return errs.Err()
+}
+
+// 校验 SeqManager 以外的配置项
+func (c *Config) validate() *ConfigError {
+	errs := &ConfigError{}
+	if c.ReadBufSize <= 0 {
+		errs.Addf("ReadBufSize must be positive, got %d", c.ReadBufSize)
+	}
+	if c.WriteBufSize <= 0 {
+		errs.Addf("WriteBufSize must be positive, got %d", c.WriteBufSize)
+	}
+	if c.ReadChanSize <= 0 {
+		errs.Addf("ReadChanSize must be positive, got %d", c.ReadChanSize)
+	}
+	if c.WriteChanSize <= 0 {
+		errs.Addf("WriteChanSize must be positive, got %d", c.WriteChanSize)
+	}
+	if c.WriteBatch <= 0 {
+		errs.Addf("WriteBatch must be positive, got %d", c.WriteBatch)
+	}
+	if c.WriteDelay < 0 {
+		errs.Addf("WriteDelay must not be negative, got %v", c.WriteDelay)
+	}
+	if c.Compression < COMPRESS_NONE || c.Compression > COMPRESS_GZIP {
+		errs.Addf("unknown compression %d", int(c.Compression))
+	}
+	if c.CompressMin < 0 {
+		errs.Addf("CompressMin must not be negative, got %d", c.CompressMin)
+	}
+	if c.ChecksumPolicy < CHECKSUM_DROP || c.ChecksumPolicy > CHECKSUM_CLOSE {
+		errs.Addf("unknown checksum policy %d", int(c.ChecksumPolicy))
+	}
+	if c.FragmentSize < 0 || c.FragmentSize > DEFAULT_MAX_PACKET_LEN/2 {
+		errs.Addf("FragmentSize must be between 0 and %d, got %d", DEFAULT_MAX_PACKET_LEN/2, c.FragmentSize)
+	}
+	if c.PartialBytes < 0 {
+		errs.Addf("PartialBytes must not be negative, got %d", c.PartialBytes)
+	}
+	if c.StreamWindow < INITIAL_WINDOW || c.StreamWindow > MAX_WINDOW {
+		errs.Addf("StreamWindow must be between %d and %d, got %d", INITIAL_WINDOW, MAX_WINDOW, c.StreamWindow)
+	}
+	if c.ConnWindow < INITIAL_WINDOW || c.ConnWindow > MAX_WINDOW {
+		errs.Addf("ConnWindow must be between %d and %d, got %d", INITIAL_WINDOW, MAX_WINDOW, c.ConnWindow)
+	}
+	if c.MaxSeq < MAX_CONCUR {
+		errs.Addf("MaxSeq %d is smaller than MAX_CONCUR %d", c.MaxSeq, MAX_CONCUR)
+	}
+	if c.IdleDuration <= 0 {
+		errs.Addf("IdleDuration must be positive, got %v", c.IdleDuration)
+	}
+	return errs
+}
+
+// 配置校验错误，记录所有不合法的配置项
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Addf(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// 合并其他校验返回的错误
+func (e *ConfigError) Merge(err error) {
+	if err == nil {
+		return
+	}
+	if ce, ok := err.(*ConfigError); ok {
+		e.Problems = append(e.Problems, ce.Problems...)
+		return
+	}
+	e.Problems = append(e.Problems, err.Error())
+}
+
+// 无问题时返回 nil
+func (e *ConfigError) Err() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid config: " + strings.Join(e.Problems, "; ")
+}
@@ -0,0 +1,576 @@
+package tron
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 配置文件中的时长，写作 "5s"、"1m30s" 等
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// 从文件与环境变量加载的完整配置
+type FileConfig struct {
+	Session   SessionFileConfig   `json:"session"`
+	Server    ServerFileConfig    `json:"server"`
+	Reconnect ReconnectFileConfig `json:"reconnect"`
+	Groups    []GroupFileConfig   `json:"groups"`
+}
+
+// 对应 Config
+type SessionFileConfig struct {
+	ReadBufSize    int      `json:"read_buf_size"`
+	WriteBufSize   int      `json:"write_buf_size"`
+	ReadChanSize   int      `json:"read_chan_size"`
+	WriteChanSize  int      `json:"write_chan_size"`
+	WriteBatch     int      `json:"write_batch"`
+	WriteDelay     Duration `json:"write_delay"`
+	Compression    string   `json:"compression"` // none、deflate 或 gzip
+	CompressMin    int      `json:"compress_min"`
+	Checksum       bool     `json:"checksum"`
+	ChecksumPolicy string   `json:"checksum_policy"` // drop 或 close
+	FragmentSize   int      `json:"fragment_size"`
+	PartialBytes   int      `json:"partial_bytes"`
+	Batch          bool     `json:"batch"`
+	StreamWindow   int      `json:"stream_window"`
+	ConnWindow     int      `json:"conn_window"`
+	MaxSeq         int32    `json:"max_seq"`
+	IdleTimeout    Duration `json:"idle_timeout"`
+}
+
+type ServerFileConfig struct {
+	Addr      string               `json:"addr"`
+	Listeners []ListenerFileConfig `json:"listeners"` // addr 以外的监听地址
+	KeepAlive Duration             `json:"keep_alive"`
+	ReusePort int                  `json:"reuse_port"`
+
+	MaxConns      int    `json:"max_conns"`
+	MaxConnsPerIP int    `json:"max_conns_per_ip"`
+	LimitPolicy   string `json:"limit_policy"` // reject、busy 或 queue
+
+	SessionRate  float64 `json:"session_rate"` // 单连接每秒请求数，0 则不限制
+	SessionBurst int     `json:"session_burst"`
+	IPRate       float64 `json:"ip_rate"` // 单 IP 每秒请求数，0 则不限制
+	IPBurst      int     `json:"ip_burst"`
+	RateAction   string  `json:"rate_action"` // delay、overload 或 disconnect
+}
+
+// 对应 ListenerConfig，codec、TLS 与独立的 session 配置需在代码中指定
+type ListenerFileConfig struct {
+	Network  string `json:"network"` // tcp4、tcp、unix 等，默认 tcp4
+	Addr     string `json:"addr"`
+	MaxConns int    `json:"max_conns"`
+}
+
+// 对应 ReconnectTaskManager
+type ReconnectFileConfig struct {
+	Timeout  Duration `json:"timeout"`
+	MaxRetry int      `json:"max_retry"`
+}
+
+// 组信息及组内需要连接的 server 地址
+type GroupFileConfig struct {
+	Gid   string   `json:"gid"`
+	Key   string   `json:"key"`
+	Addrs []string `json:"addrs"`
+}
+
+const ENV_PREFIX = "TRON"
+
+func DefaultFileConfig() *FileConfig {
+	return &FileConfig{
+		Session: SessionFileConfig{
+			ReadBufSize:    DEFAULT_BUF_SIZE,
+			WriteBufSize:   DEFAULT_BUF_SIZE,
+			ReadChanSize:   DEFAULT_CHAN_SIZE,
+			WriteChanSize:  DEFAULT_CHAN_SIZE,
+			WriteBatch:     DEFAULT_BATCH,
+			Compression:    COMPRESS_NONE.String(),
+			CompressMin:    DEFAULT_COMPRESS_MIN,
+			StreamWindow:   DEFAULT_STREAM_WINDOW,
+			ConnWindow:     DEFAULT_CONN_WINDOW,
+			ChecksumPolicy: CHECKSUM_DROP.String(),
+			MaxSeq:         DEFAULT_MAX_SEQ,
+			IdleTimeout:    Duration(DEFAULT_IDLE),
+		},
+		Server: ServerFileConfig{
+			KeepAlive:   Duration(5 * time.Second),
+			LimitPolicy: LIMIT_REJECT.String(),
+			RateAction:  RATE_DELAY.String(),
+		},
+		Reconnect: ReconnectFileConfig{
+			Timeout:  Duration(5 * time.Second),
+			MaxRetry: 3,
+		},
+	}
+}
+
+// 按扩展名（.json / .toml）解析配置文件，再以 TRON_ 前缀的环境变量覆盖
+func LoadFileConfig(path string) (*FileConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fc, err := ParseFileConfig(data, strings.TrimPrefix(filepath.Ext(path), "."))
+	if err != nil {
+		return nil, err
+	}
+	if err := fc.ApplyEnv(ENV_PREFIX, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := fc.Validate(); err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
+
+// 解析 json 或 toml 格式的配置，未出现的配置项保留默认值
+func ParseFileConfig(data []byte, format string) (*FileConfig, error) {
+	var raw map[string]interface{}
+	switch strings.ToLower(format) {
+	case "json":
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+		if err := checkJSONDuplicates(json.NewDecoder(bytes.NewReader(data)), ""); err != nil {
+			return nil, err
+		}
+	case "toml":
+		var err error
+		if raw, err = parseTOML(data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+
+	// 先按 schema 校验，一次报告全部问题
+	errs := &ConfigError{}
+	checkSchema(errs, "", raw, reflect.TypeOf(FileConfig{}))
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+
+	normalized, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	fc := DefaultFileConfig()
+	if err := json.Unmarshal(normalized, fc); err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
+
+// encoding/json 遇到重复的键时静默取最后一个，配置中多半是笔误
+// 调用前 data 已通过语法检查
+func checkJSONDuplicates(dec *json.Decoder, path string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('{'):
+		seen := make(map[string]bool)
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key := joinPath(path, tok.(string))
+			if seen[key] {
+				return fmt.Errorf("json: duplicate key %q", key)
+			}
+			seen[key] = true
+			if err := checkJSONDuplicates(dec, key); err != nil {
+				return err
+			}
+		}
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := checkJSONDuplicates(dec, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+	_, err = dec.Token() // 结束的 } 或 ]
+	return err
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// 检查原始配置的键与值类型是否与结构体定义一致
+func checkSchema(errs *ConfigError, path string, v interface{}, t reflect.Type) {
+	if t == durationType {
+		s, ok := v.(string)
+		if !ok {
+			errs.Addf("%s: expected duration string, got %s", path, jsonTypeName(v))
+			return
+		}
+		if _, err := time.ParseDuration(s); err != nil {
+			errs.Addf("%s: invalid duration %q", path, s)
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			errs.Addf("%s: expected table, got %s", schemaPath(path), jsonTypeName(v))
+			return
+		}
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			fields[jsonFieldName(t.Field(i))] = t.Field(i).Type
+		}
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys) // 报告顺序稳定
+		for _, key := range keys {
+			fv := m[key]
+			ft, ok := fields[key]
+			if !ok {
+				errs.Addf("%s: unknown key", joinPath(path, key))
+				continue
+			}
+			checkSchema(errs, joinPath(path, key), fv, ft)
+		}
+	case reflect.Slice:
+		arr, ok := v.([]interface{})
+		if !ok {
+			errs.Addf("%s: expected array, got %s", path, jsonTypeName(v))
+			return
+		}
+		for i, item := range arr {
+			checkSchema(errs, fmt.Sprintf("%s[%d]", path, i), item, t.Elem())
+		}
+	case reflect.String:
+		if _, ok := v.(string); !ok {
+			errs.Addf("%s: expected string, got %s", path, jsonTypeName(v))
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			errs.Addf("%s: expected bool, got %s", path, jsonTypeName(v))
+		}
+	case reflect.Float64:
+		switch v.(type) {
+		case int64, float64:
+		default:
+			errs.Addf("%s: expected number, got %s", path, jsonTypeName(v))
+		}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		var n float64
+		switch num := v.(type) {
+		case int64:
+			n = float64(num)
+		case float64:
+			n = num
+		default:
+			errs.Addf("%s: expected integer, got %s", path, jsonTypeName(v))
+			return
+		}
+		if n != float64(int64(n)) {
+			errs.Addf("%s: expected integer, got %v", path, n)
+			return
+		}
+		if reflect.Zero(t).OverflowInt(int64(n)) {
+			errs.Addf("%s: %v overflows %s", path, n, t.Kind())
+		}
+	}
+}
+
+// 以 PREFIX_SECTION_KEY 形式的环境变量覆盖配置，如 TRON_SESSION_READ_BUF_SIZE
+// 字符串数组以逗号分隔，groups 与 server.listeners 不支持覆盖
+func (fc *FileConfig) ApplyEnv(prefix string, lookup func(key string) (string, bool)) error {
+	errs := &ConfigError{}
+	applyEnv(errs, prefix, reflect.ValueOf(fc).Elem(), lookup)
+	return errs.Err()
+}
+
+func applyEnv(errs *ConfigError, name string, v reflect.Value, lookup func(key string) (string, bool)) {
+	if v.Kind() == reflect.Struct && v.Type() != durationType {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			key := name + "_" + strings.ToUpper(jsonFieldName(field))
+			applyEnv(errs, key, v.Field(i), lookup)
+		}
+		return
+	}
+
+	s, ok := lookup(name)
+	if !ok {
+		return
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			errs.Addf("%s: invalid duration %q", name, s)
+			return
+		}
+		v.SetInt(int64(d))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			errs.Addf("%s: invalid bool %q", name, s)
+			return
+		}
+		v.SetBool(b)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			errs.Addf("%s: invalid number %q", name, s)
+			return
+		}
+		v.SetFloat(f)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			errs.Addf("%s: invalid integer %q", name, s)
+			return
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			errs.Addf("%s: cannot be set from environment", name)
+			return
+		}
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	}
+}
+
+// 校验配置取值
+func (fc *FileConfig) Validate() error {
+	_, errs := fc.sessionConfig()
+	for i, l := range fc.Server.Listeners {
+		if l.Addr == "" {
+			errs.Addf("server.listeners[%d].addr is empty", i)
+		}
+		if l.MaxConns < 0 {
+			errs.Addf("server.listeners[%d].max_conns must not be negative, got %d", i, l.MaxConns)
+		}
+	}
+	if fc.Server.KeepAlive < 0 {
+		errs.Addf("server.keep_alive must not be negative, got %v", time.Duration(fc.Server.KeepAlive))
+	}
+	if _, err := ParseLimitPolicy(fc.Server.LimitPolicy); err != nil {
+		errs.Addf("server.limit_policy: %v", err)
+	}
+	if _, err := ParseRateAction(fc.Server.RateAction); err != nil {
+		errs.Addf("server.rate_action: %v", err)
+	}
+	if fc.Reconnect.Timeout <= 0 {
+		errs.Addf("reconnect.timeout must be positive, got %v", time.Duration(fc.Reconnect.Timeout))
+	}
+	if fc.Reconnect.MaxRetry <= 0 {
+		errs.Addf("reconnect.max_retry must be positive, got %d", fc.Reconnect.MaxRetry)
+	}
+	gids := make(map[string]bool)
+	for i, g := range fc.Groups {
+		if g.Gid == "" {
+			errs.Addf("groups[%d].gid is empty", i)
+		}
+		if gids[g.Gid] {
+			errs.Addf("groups[%d].gid %q is duplicated", i, g.Gid)
+		}
+		gids[g.Gid] = true
+	}
+	return errs.Err()
+}
+
+// 生成 session 配置
+func (fc *FileConfig) Config() (*Config, error) {
+	conf, errs := fc.sessionConfig()
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	conf.SeqManager = NewSeqManager(conf.MaxSeq)
+	return conf, nil
+}
+
+// 按文件中的取值生成不含 SeqManager 的 Config，并返回全部不合法的取值
+func (fc *FileConfig) sessionConfig() (*Config, *ConfigError) {
+	errs := &ConfigError{}
+	compression, err := ParseCompression(fc.Session.Compression)
+	if err != nil {
+		errs.Addf("session.compression: %v", err)
+	}
+	policy, err := ParseChecksumPolicy(fc.Session.ChecksumPolicy)
+	if err != nil {
+		errs.Addf("session.checksum_policy: %v", err)
+	}
+	conf := &Config{
+		ReadBufSize:    fc.Session.ReadBufSize,
+		WriteBufSize:   fc.Session.WriteBufSize,
+		ReadChanSize:   fc.Session.ReadChanSize,
+		WriteChanSize:  fc.Session.WriteChanSize,
+		WriteBatch:     fc.Session.WriteBatch,
+		WriteDelay:     time.Duration(fc.Session.WriteDelay),
+		Compression:    compression,
+		CompressMin:    fc.Session.CompressMin,
+		Checksum:       fc.Session.Checksum,
+		ChecksumPolicy: policy,
+		FragmentSize:   fc.Session.FragmentSize,
+		PartialBytes:   fc.Session.PartialBytes,
+		Batch:          fc.Session.Batch,
+		StreamWindow:   fc.Session.StreamWindow,
+		ConnWindow:     fc.Session.ConnWindow,
+		MaxSeq:         fc.Session.MaxSeq,
+		IdleDuration:   time.Duration(fc.Session.IdleTimeout),
+	}
+	errs.Merge(conf.validate().Err())
+	return conf, errs
+}
+
+// 生成 NewServerWith 或 Server.Reload 使用的 opts
+func (fc *FileConfig) ServerOptions() ([]ServerOption, error) {
+	conf, err := fc.Config()
+	if err != nil {
+		return nil, err
+	}
+	policy, err := ParseLimitPolicy(fc.Server.LimitPolicy)
+	if err != nil {
+		return nil, err
+	}
+	action, err := ParseRateAction(fc.Server.RateAction)
+	if err != nil {
+		return nil, err
+	}
+	opts := []ServerOption{
+		WithServerConfig(conf),
+		WithServerKeepAlive(time.Duration(fc.Server.KeepAlive)),
+		WithServerReusePort(fc.Server.ReusePort),
+		WithServerMaxConns(fc.Server.MaxConns),
+		WithServerMaxConnsPerIP(fc.Server.MaxConnsPerIP),
+		WithServerLimitPolicy(policy),
+		WithServerSessionRate(RateLimit{Rate: fc.Server.SessionRate, Burst: fc.Server.SessionBurst}),
+		WithServerIPRate(RateLimit{Rate: fc.Server.IPRate, Burst: fc.Server.IPBurst}),
+		WithServerRateAction(action),
+	}
+	if fc.Server.Addr != "" {
+		opts = append(opts, WithServerAddr(fc.Server.Addr))
+	}
+	for _, l := range fc.Server.Listeners {
+		opts = append(opts, WithServerListener(ListenerConfig{Network: l.Network, Addr: l.Addr, MaxConns: l.MaxConns}))
+	}
+	return opts, nil
+}
+
+func (fc *FileConfig) ReconnectTaskManager() *ReconnectTaskManager {
+	return NewReconnectTaskManager(time.Duration(fc.Reconnect.Timeout), fc.Reconnect.MaxRetry)
+}
+
+// 连接各组内的 server 并加入 manager，opts 用于指定 handler 等拨号参数
+// 全部连接成功后才加入 manager，任一失败时关闭已建立的连接
+func (fc *FileConfig) DialGroups(ctx context.Context, m *ClientsManager, opts ...DialOption) error {
+	type member struct {
+		group *ClientGroup
+		cli   *Client
+	}
+	var members []member
+	fail := func(err error) error {
+		for _, mb := range members {
+			mb.cli.Close()
+		}
+		return err
+	}
+	for _, g := range fc.Groups {
+		group := NewClientsGroup(g.Gid, g.Key)
+		for _, addr := range g.Addrs {
+			conf, err := fc.Config()
+			if err != nil {
+				return fail(err)
+			}
+			cli, err := Dial(ctx, addr, append([]DialOption{WithClientConfig(conf)}, opts...)...)
+			if err != nil {
+				return fail(fmt.Errorf("group %s: dial %s: %v", g.Gid, addr, err))
+			}
+			members = append(members, member{group, cli})
+		}
+	}
+	for _, mb := range members {
+		m.Add(mb.group, mb.cli)
+	}
+	return nil
+}
+
+func jsonFieldName(f reflect.StructField) string {
+	if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "" {
+		return tag
+	}
+	return f.Name
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func schemaPath(path string) string {
+	if path == "" {
+		return "<root>"
+	}
+	return path
+}
+
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int64, float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "table"
+	}
+	return fmt.Sprintf("%T", v)
+}
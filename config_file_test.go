@@ -0,0 +1,326 @@
+package tron
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFileConfig(t *testing.T) {
+	want := DefaultFileConfig()
+	want.Session.ReadBufSize = 8192
+	want.Session.WriteDelay = Duration(2 * time.Millisecond)
+	want.Session.Compression = "gzip"
+	want.Session.Checksum = true
+	want.Server.Addr = ":9000"
+	want.Server.SessionRate = 1.5
+	want.Server.Listeners = []ListenerFileConfig{
+		{Addr: ":9001"},
+		{Network: "unix", Addr: "/tmp/tron.sock", MaxConns: 10},
+	}
+	want.Groups = []GroupFileConfig{
+		{Gid: "a", Key: "ka", Addrs: []string{"127.0.0.1:1", "127.0.0.1:2"}},
+		{Gid: "b", Addrs: []string{}},
+	}
+
+	cases := []struct {
+		name   string
+		format string
+		data   string
+	}{
+		{"json", "json", `{
+			"session": {"read_buf_size": 8192, "write_delay": "2ms", "compression": "gzip", "checksum": true},
+			"server": {"addr": ":9000", "session_rate": 1.5, "listeners": [
+				{"addr": ":9001"},
+				{"network": "unix", "addr": "/tmp/tron.sock", "max_conns": 10}
+			]},
+			"groups": [
+				{"gid": "a", "key": "ka", "addrs": ["127.0.0.1:1", "127.0.0.1:2"]},
+				{"gid": "b", "addrs": []}
+			]
+		}`},
+		{"toml", "TOML", `
+# 注释
+[session]
+read_buf_size = 8_192
+write_delay = "2ms"   # 行尾注释
+compression = 'gzip'
+checksum = true
+
+[server]
+"addr" = ":9000"
+session_rate = 1.5
+
+[[server.listeners]]
+addr = ":9001"
+
+[[server.listeners]]
+network = "unix"
+addr = "/tmp/tron.sock"
+max_conns = 10
+
+[[groups]]
+gid = "a"
+key = "k#a"
+addrs = [
+	"127.0.0.1:1",
+	"127.0.0.1:2", # 末尾逗号
+]
+
+[[groups]]
+gid = "b"
+addrs = []
+`},
+	}
+	for _, c := range cases {
+		if c.format == "TOML" {
+			want.Groups[0].Key = "k#a"
+		} else {
+			want.Groups[0].Key = "ka"
+		}
+		fc, err := ParseFileConfig([]byte(c.data), c.format)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(fc, want) {
+			t.Fatalf("%s: got %+v, want %+v", c.name, fc, want)
+		}
+	}
+}
+
+func TestParseFileConfigRejectsMalformed(t *testing.T) {
+	cases := []struct {
+		name   string
+		format string
+		data   string
+		want   string
+	}{
+		{"format", "yaml", `a: 1`, "unsupported config format"},
+		{"json syntax", "json", `{"session": {`, "unexpected end"},
+		{"json duplicate key", "json", `{"session": {"max_seq": 200, "max_seq": 300}}`, `duplicate key "session.max_seq"`},
+		{"json unknown key", "json", `{"session": {"read_buf": 1}}`, "session.read_buf"},
+		{"json wrong type", "json", `{"session": {"read_buf_size": "1k"}}`, "session.read_buf_size"},
+		{"json bad duration", "json", `{"session": {"write_delay": 5}}`, "session.write_delay"},
+		{"toml unterminated string", "toml", "[server]\naddr = \":9000\n", "line 2: key \"addr\": unterminated string"},
+		{"toml unterminated single quote", "toml", "[server]\naddr = ':9000\n", "unterminated string"},
+		{"toml unterminated array", "toml", "[[groups]]\naddrs = [\"a\", \"b\"\n", "unterminated array"},
+		{"toml empty array element", "toml", "[[groups]]\naddrs = [\"a\", , \"b\"]\n", "empty element"},
+		{"toml bad array element", "toml", "[[groups]]\naddrs = [\"a\", b]\n", "unsupported value b"},
+		{"toml unterminated table", "toml", "[session\n", "line 1: unterminated table"},
+		{"toml unterminated array table", "toml", "[[groups]\n", "line 1: unterminated array table"},
+		{"toml empty table name", "toml", "[session.]\n", "invalid table name"},
+		{"toml duplicate key", "toml", "[session]\nmax_seq = 200\nmax_seq = 300\n", `line 3: duplicate key "max_seq"`},
+		{"toml duplicate table", "toml", "[session]\nmax_seq = 200\n[server]\n[session]\n", "line 4: duplicate table [session]"},
+		{"toml missing key", "toml", "[session]\n= 1\n", "line 2: missing key"},
+		{"toml missing value", "toml", "[session]\nmax_seq =\n", "missing value"},
+		{"toml not key value", "toml", "[session]\nmax_seq\n", "expected key = value"},
+		{"toml table over value", "toml", "session = 1\n[session]\n", "not a table"},
+		{"toml unknown key", "toml", "[server]\nport = 1\n", "server.port"},
+		{"toml dotted key", "toml", "session.max_seq = 200\n", "line 1: dotted key session.max_seq is not supported"},
+		{"toml quoted dotted key", "toml", "[session]\n\"max.seq\" = 200\n", "unsupported quoted key"},
+		{"toml quoted table name", "toml", "[\"session.x\"]\n", "invalid table name"},
+		{"toml invalid key", "toml", "[session]\nmax seq = 200\n", "invalid key"},
+		{"toml inline table", "toml", "session = {max_seq = 200}\n", "inline tables are not supported"},
+		{"toml multi-line string", "toml", "[server]\naddr = \"\"\"\n:9000\"\"\"\n", "multi-line strings"},
+		{"toml leading zero", "toml", "[session]\nmax_seq = 0200\n", "leading zeros"},
+		{"toml trailing value", "toml", "[server]\naddr = ':9000' ':9001'\n", "invalid string"},
+	}
+	for _, c := range cases {
+		fc, err := ParseFileConfig([]byte(c.data), c.format)
+		if err == nil {
+			t.Fatalf("%s: want error, got %+v", c.name, fc)
+		}
+		if !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("%s: error %q does not mention %q", c.name, err, c.want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	cases := []struct {
+		name  string
+		env   map[string]string
+		check func(fc *FileConfig) bool
+		want  string // 期望的错误信息，为空表示成功
+	}{
+		{"int", map[string]string{"TRON_SESSION_READ_BUF_SIZE": "1024"},
+			func(fc *FileConfig) bool { return fc.Session.ReadBufSize == 1024 }, ""},
+		{"int32", map[string]string{"TRON_SESSION_MAX_SEQ": "4096"},
+			func(fc *FileConfig) bool { return fc.Session.MaxSeq == 4096 }, ""},
+		{"duration", map[string]string{"TRON_RECONNECT_TIMEOUT": "1m"},
+			func(fc *FileConfig) bool { return fc.Reconnect.Timeout == Duration(time.Minute) }, ""},
+		{"bool", map[string]string{"TRON_SESSION_CHECKSUM": "true"},
+			func(fc *FileConfig) bool { return fc.Session.Checksum }, ""},
+		{"float", map[string]string{"TRON_SERVER_IP_RATE": "2.5"},
+			func(fc *FileConfig) bool { return fc.Server.IPRate == 2.5 }, ""},
+		{"string", map[string]string{"TRON_SERVER_ADDR": ":7000"},
+			func(fc *FileConfig) bool { return fc.Server.Addr == ":7000" }, ""},
+		{"other prefix ignored", map[string]string{"APP_SERVER_ADDR": ":7000"},
+			func(fc *FileConfig) bool { return fc.Server.Addr == ":9000" }, ""},
+		{"invalid int", map[string]string{"TRON_SESSION_READ_BUF_SIZE": "1k"}, nil,
+			`TRON_SESSION_READ_BUF_SIZE: invalid integer "1k"`},
+		{"int32 overflow", map[string]string{"TRON_SESSION_MAX_SEQ": "4294967296"}, nil,
+			"TRON_SESSION_MAX_SEQ: invalid integer"},
+		{"invalid duration", map[string]string{"TRON_SESSION_WRITE_DELAY": "5"}, nil,
+			`TRON_SESSION_WRITE_DELAY: invalid duration "5"`},
+		{"invalid bool", map[string]string{"TRON_SESSION_CHECKSUM": "yes"}, nil,
+			`TRON_SESSION_CHECKSUM: invalid bool "yes"`},
+		{"invalid float", map[string]string{"TRON_SERVER_IP_RATE": "fast"}, nil,
+			`TRON_SERVER_IP_RATE: invalid number "fast"`},
+		{"groups", map[string]string{"TRON_GROUPS": "a"}, nil,
+			"TRON_GROUPS: cannot be set from environment"},
+	}
+	for _, c := range cases {
+		fc := DefaultFileConfig()
+		fc.Server.Addr = ":9000"
+		err := fc.ApplyEnv(ENV_PREFIX, func(key string) (string, bool) {
+			v, ok := c.env[key]
+			return v, ok
+		})
+		if c.want != "" {
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("%s: error %v does not mention %q", c.name, err, c.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !c.check(fc) {
+			t.Fatalf("%s: override not applied: %+v", c.name, fc)
+		}
+	}
+}
+
+// 多个环境变量有误时一次全部报告
+func TestApplyEnvReportsAllProblems(t *testing.T) {
+	env := map[string]string{
+		"TRON_SESSION_READ_BUF_SIZE": "x",
+		"TRON_SERVER_KEEP_ALIVE":     "y",
+	}
+	err := DefaultFileConfig().ApplyEnv(ENV_PREFIX, func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	})
+	var ce *ConfigError
+	if !errors.As(err, &ce) || len(ce.Problems) != 2 {
+		t.Fatalf("want 2 problems, got %v", err)
+	}
+}
+
+func TestLoadFileConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tron-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"tron.json": `{"session": {"max_seq": 2048}, "server": {"addr": ":9000"}}`,
+		"tron.toml": "[session]\nmax_seq = 2048\n[server]\naddr = \":9000\"\n",
+	}
+	os.Setenv("TRON_SERVER_ADDR", ":9100")
+	defer os.Unsetenv("TRON_SERVER_ADDR")
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fc, err := LoadFileConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if fc.Session.MaxSeq != 2048 || fc.Server.Addr != ":9100" {
+			t.Fatalf("%s: got session %+v, server %+v", name, fc.Session, fc.Server)
+		}
+	}
+
+	// 取值不合法时 Validate 报错
+	path := filepath.Join(dir, "listener.json")
+	ioutil.WriteFile(path, []byte(`{"server": {"listeners": [{"network": "unix"}]}}`), 0644)
+	if _, err := LoadFileConfig(path); err == nil || !strings.Contains(err.Error(), "server.listeners[0].addr is empty") {
+		t.Fatalf("want listener addr error, got %v", err)
+	}
+	path = filepath.Join(dir, "bad.json")
+	ioutil.WriteFile(path, []byte(`{"session": {"max_seq": 1}}`), 0644)
+	if _, err := LoadFileConfig(path); err == nil || !strings.Contains(err.Error(), "MaxSeq") {
+		t.Fatalf("want MaxSeq error, got %v", err)
+	}
+}
+
+// server.listeners 转为额外的监听地址
+func TestFileConfigServerOptions(t *testing.T) {
+	fc := DefaultFileConfig()
+	fc.Server.Addr = ":9000"
+	fc.Server.Listeners = []ListenerFileConfig{{Network: "unix", Addr: "/tmp/tron.sock", MaxConns: 10}}
+	opts, err := fc.ServerOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServerWith(":1", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ListenerConfig{{Network: "unix", Addr: "/tmp/tron.sock", MaxConns: 10}}
+	if s.address != ":9000" || !reflect.DeepEqual(s.extra, want) {
+		t.Fatalf("got address %q, listeners %+v", s.address, s.extra)
+	}
+}
+
+// 任一地址连接失败时，已建立的连接被关闭且不加入 manager
+func TestDialGroupsClosesOnFailure(t *testing.T) {
+	l := NewLoopbackListener("groups")
+	defer l.Close()
+	s, err := NewServerWith(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(l); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+
+	fc := DefaultFileConfig()
+	fc.Groups = []GroupFileConfig{
+		{Gid: "a", Addrs: []string{"ok:1", "ok:2"}},
+		{Gid: "b", Addrs: []string{"bad:1"}},
+	}
+	var dialed []*Client
+	m := NewClientsManager(NewReconnectTaskManager(time.Second, 1))
+	err = fc.DialGroups(context.Background(), m,
+		WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if strings.HasPrefix(addr, "bad") {
+				return nil, errors.New("refused")
+			}
+			return l.Dial()
+		}),
+		WithClientHandler(func(cli *Client, p *Packet) {}),
+	)
+	if err == nil || !strings.Contains(err.Error(), "group b: dial bad:1") {
+		t.Fatalf("want dial error, got %v", err)
+	}
+
+	m.lock.Lock()
+	for _, cli := range m.allClients {
+		dialed = append(dialed, cli)
+	}
+	m.lock.Unlock()
+	if len(dialed) != 0 {
+		t.Fatalf("manager holds %d clients after failure", len(dialed))
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for s.NumSessions() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := s.NumSessions(); n != 0 {
+		t.Fatalf("%d sessions still open after failed DialGroups", n)
+	}
+}
@@ -0,0 +1,150 @@
+package tron
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildConfigRejectsInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		opt  ConfigOption
+		want string
+	}{
+		{"read buf", WithReadBufSize(0), "ReadBufSize"},
+		{"write buf", WithWriteBufSize(-1), "WriteBufSize"},
+		{"read chan", WithReadChanSize(0), "ReadChanSize"},
+		{"write chan", WithWriteChanSize(0), "WriteChanSize"},
+		{"write batch", WithWriteBatch(0), "WriteBatch"},
+		{"write delay", WithWriteDelay(-time.Millisecond), "WriteDelay"},
+		{"compression", WithCompression(Compression(9)), "compression"},
+		{"compress min", WithCompressMin(-1), "CompressMin"},
+		{"checksum policy", WithChecksumPolicy(ChecksumPolicy(9)), "checksum policy"},
+		{"fragment size", WithFragmentSize(DEFAULT_MAX_PACKET_LEN), "FragmentSize"},
+		{"partial bytes", WithPartialBytes(-1), "PartialBytes"},
+		{"stream window", WithStreamWindow(INITIAL_WINDOW - 1), "StreamWindow"},
+		{"conn window", WithConnWindow(MAX_WINDOW + 1), "ConnWindow"},
+		{"max seq", WithMaxSeq(MAX_CONCUR - 1), "MaxSeq"},
+		{"idle", WithIdleDuration(0), "IdleDuration"},
+	}
+	for _, c := range cases {
+		conf, err := BuildConfig(c.opt)
+		if err == nil || conf != nil {
+			t.Fatalf("%s: want error, got config %+v", c.name, conf)
+		}
+		if !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("%s: error %q does not mention %s", c.name, err, c.want)
+		}
+	}
+
+	// 所有问题一并返回
+	_, err := BuildConfig(WithReadBufSize(0), WithWriteBatch(0), WithIdleDuration(0))
+	ce, ok := err.(*ConfigError)
+	if !ok || len(ce.Problems) != 3 {
+		t.Fatalf("want 3 problems, got %v", err)
+	}
+	if _, err := BuildConfig(); err != nil {
+		t.Fatalf("default config invalid: %v", err)
+	}
+}
+
+func TestServerOptionsRejectInvalid(t *testing.T) {
+	frag, small := fragmentConfig(t), NewDefaultCodec(WithMaxPacketLen(1024))
+	cases := []struct {
+		name string
+		addr string
+		opts []ServerOption
+		want string
+	}{
+		{"empty addr", "", nil, "address is empty"},
+		{"keepalive", ":0", []ServerOption{WithServerKeepAlive(-time.Second)}, "keepAlive"},
+		{"reuse port", ":0", []ServerOption{WithServerReusePort(-1)}, "reusePort"},
+		{"max conns", ":0", []ServerOption{WithServerMaxConns(-1)}, "maxConns"},
+		{"per ip", ":0", []ServerOption{WithServerMaxConnsPerIP(-1)}, "maxConnsPerIP"},
+		{"limit policy", ":0", []ServerOption{WithServerLimitPolicy(LimitPolicy(9))}, "limit policy"},
+		{"session rate", ":0", []ServerOption{WithServerSessionRate(RateLimit{Rate: -1})}, "session rate"},
+		{"command func", ":0", []ServerOption{WithServerCommandRate("get", RateLimit{Rate: 1})}, "command func"},
+		{"rate action", ":0", []ServerOption{WithServerRateAction(RateAction(9))}, "rate action"},
+		{"listener addr", ":0", []ServerOption{WithServerListener(ListenerConfig{})}, "listener[0] address is empty"},
+		{"listener dup", ":0", []ServerOption{WithServerListener(ListenerConfig{Addr: ":0"})}, "duplicated"},
+		{"listener conns", ":0", []ServerOption{WithServerListener(ListenerConfig{Addr: ":1", MaxConns: -1})}, "listener[0] maxConns"},
+		{"config", ":0", []ServerOption{WithServerConfig(&Config{})}, "ReadBufSize"},
+		{"config seq manager", ":0", []ServerOption{WithServerConfig(handBuiltConfig(nil))}, "SeqManager is nil"},
+		{"config max seq", ":0", []ServerOption{WithServerConfig(handBuiltConfig(NewSeqManager(2 * DEFAULT_MAX_SEQ)))}, "does not match MaxSeq"},
+		{"fragment codec", ":0", []ServerOption{WithServerConfig(frag), WithServerCodec(small)}, "allowed by codec"},
+		{"listener fragment codec", ":0", []ServerOption{WithServerConfig(frag), WithServerListener(ListenerConfig{Addr: ":1", Codec: small})}, "allowed by codec"},
+	}
+	for _, c := range cases {
+		s, err := NewServerWith(c.addr, c.opts...)
+		if err == nil || s != nil {
+			t.Fatalf("%s: want error", c.name)
+		}
+		if !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("%s: error %q does not mention %q", c.name, err, c.want)
+		}
+	}
+}
+
+func TestDialOptionsRejectInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		addr string
+		opts []DialOption
+		want string
+	}{
+		{"empty addr", "", nil, "address is empty"},
+		{"network", "127.0.0.1:1", []DialOption{WithNetwork("")}, "network is empty"},
+		{"timeout", "127.0.0.1:1", []DialOption{WithDialTimeout(-time.Second)}, "timeout"},
+		{"config", "127.0.0.1:1", []DialOption{WithClientConfig(&Config{})}, "ReadBufSize"},
+		{"config seq manager", "127.0.0.1:1", []DialOption{WithClientConfig(handBuiltConfig(nil))}, "SeqManager is nil"},
+		{"fragment codec", "127.0.0.1:1", []DialOption{WithClientConfig(fragmentConfig(t)), WithClientCodec(NewDefaultCodec(WithMaxPacketLen(1024)))}, "allowed by codec"},
+	}
+	for _, c := range cases {
+		cli, err := Dial(context.Background(), c.addr, c.opts...)
+		if err == nil || cli != nil {
+			t.Fatalf("%s: want error", c.name)
+		}
+		if !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("%s: error %q does not mention %q", c.name, err, c.want)
+		}
+	}
+}
+
+// 手动构造的 Config 设置了 SeqManager 即可使用
+func TestHandBuiltConfig(t *testing.T) {
+	conf := handBuiltConfig(NewSeqManager(DEFAULT_MAX_SEQ))
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("hand-built config invalid: %v", err)
+	}
+	s, err := NewServerWith(":0", WithServerConfig(conf))
+	if err != nil || s == nil {
+		t.Fatalf("new server failed: %v", err)
+	}
+}
+
+// 分片大于 1024 字节包长上限的配置
+func fragmentConfig(t *testing.T) *Config {
+	conf, err := BuildConfig(WithFragmentSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+// 除 SeqManager 外各项均合法的手动构造配置
+func handBuiltConfig(m *SeqManager) *Config {
+	return &Config{
+		ReadBufSize:   DEFAULT_BUF_SIZE,
+		WriteBufSize:  DEFAULT_BUF_SIZE,
+		ReadChanSize:  DEFAULT_CHAN_SIZE,
+		WriteChanSize: DEFAULT_CHAN_SIZE,
+		WriteBatch:    DEFAULT_BATCH,
+		StreamWindow:  DEFAULT_STREAM_WINDOW,
+		ConnWindow:    DEFAULT_CONN_WINDOW,
+		MaxSeq:        DEFAULT_MAX_SEQ,
+		IdleDuration:  DEFAULT_IDLE,
+		SeqManager:    m,
+	}
+}
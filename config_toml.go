@@ -0,0 +1,311 @@
+package tron
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 解析配置所需的 TOML 子集：
+// 注释、[table]、[[array]]、dotted table 名、字符串 / 整数 / 浮点 / 布尔及其数组
+// dotted key、inline table、多行字符串、日期等其余语法直接报错，不做猜测
+// 解析结果与 encoding/json 解析到 interface{} 的结构一致，方便统一校验
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	cur := root
+	defined := make(map[uintptr]bool) // 已由 [table] 定义的 table，不可重复定义
+
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(stripTOMLComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		// [[array.of.tables]]
+		if strings.HasPrefix(line, "[[") {
+			if !strings.HasSuffix(line, "]]") {
+				return nil, fmt.Errorf("toml line %d: unterminated array table %q", lineNo, line)
+			}
+			table, err := tomlArrayTable(root, strings.TrimSpace(line[2:len(line)-2]))
+			if err != nil {
+				return nil, fmt.Errorf("toml line %d: %v", lineNo, err)
+			}
+			cur = table
+			continue
+		}
+
+		// [table]
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("toml line %d: unterminated table %q", lineNo, line)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			table, err := tomlTable(root, name)
+			if err != nil {
+				return nil, fmt.Errorf("toml line %d: %v", lineNo, err)
+			}
+			if id := reflect.ValueOf(table).Pointer(); defined[id] {
+				return nil, fmt.Errorf("toml line %d: duplicate table [%s]", lineNo, name)
+			} else {
+				defined[id] = true
+			}
+			cur = table
+			continue
+		}
+
+		// key = value
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("toml line %d: expected key = value, got %q", lineNo, line)
+		}
+		key, err := parseTOMLKey(strings.TrimSpace(line[:eq]))
+		if err != nil {
+			return nil, fmt.Errorf("toml line %d: %v", lineNo, err)
+		}
+		raw := strings.TrimSpace(line[eq+1:])
+
+		// 跨行数组直到括号闭合
+		for strings.HasPrefix(raw, "[") && !tomlArrayClosed(raw) && i+1 < len(lines) {
+			i++
+			raw += " " + strings.TrimSpace(stripTOMLComment(lines[i]))
+		}
+
+		v, err := parseTOMLValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("toml line %d: key %q: %v", lineNo, key, err)
+		}
+		if _, ok := cur[key]; ok {
+			return nil, fmt.Errorf("toml line %d: duplicate key %q", lineNo, key)
+		}
+		cur[key] = v
+	}
+	return root, nil
+}
+
+func tomlTable(root map[string]interface{}, name string) (map[string]interface{}, error) {
+	cur := root
+	for _, part := range strings.Split(name, ".") {
+		part, err := parseTOMLKey(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid table name %q: %v", name, err)
+		}
+		switch v := cur[part].(type) {
+		case nil:
+			next := make(map[string]interface{})
+			cur[part] = next
+			cur = next
+		case map[string]interface{}:
+			cur = v
+		case []interface{}: // 指向数组中最后一个 table
+			if len(v) == 0 {
+				return nil, fmt.Errorf("table %q is empty array", name)
+			}
+			last, ok := v[len(v)-1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("key %q is not a table", part)
+			}
+			cur = last
+		default:
+			return nil, fmt.Errorf("key %q is not a table", part)
+		}
+	}
+	return cur, nil
+}
+
+func tomlArrayTable(root map[string]interface{}, name string) (map[string]interface{}, error) {
+	parent := root
+	parts := strings.Split(name, ".")
+	if len(parts) > 1 {
+		var err error
+		if parent, err = tomlTable(root, strings.Join(parts[:len(parts)-1], ".")); err != nil {
+			return nil, err
+		}
+	}
+
+	last, err := parseTOMLKey(strings.TrimSpace(parts[len(parts)-1]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid table name %q: %v", name, err)
+	}
+	table := make(map[string]interface{})
+	switch v := parent[last].(type) {
+	case nil:
+		parent[last] = []interface{}{table}
+	case []interface{}:
+		parent[last] = append(v, table)
+	default:
+		return nil, fmt.Errorf("key %q is not an array of tables", last)
+	}
+	return table, nil
+}
+
+func parseTOMLValue(raw string) (interface{}, error) {
+	switch {
+	case raw == "":
+		return nil, fmt.Errorf("missing value")
+	case raw == "true":
+		return true, nil
+	case raw == "false":
+		return false, nil
+	case strings.HasPrefix(raw, `"""`) || strings.HasPrefix(raw, "'''"):
+		return nil, fmt.Errorf("multi-line strings are not supported")
+	case raw[0] == '"':
+		if len(raw) < 2 || raw[len(raw)-1] != '"' {
+			return nil, fmt.Errorf("unterminated string %s", raw)
+		}
+		v, err := strconv.Unquote(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", raw)
+		}
+		return v, nil
+	case raw[0] == '\'':
+		if len(raw) < 2 || raw[len(raw)-1] != '\'' {
+			return nil, fmt.Errorf("unterminated string %s", raw)
+		}
+		if v := raw[1 : len(raw)-1]; !strings.Contains(v, "'") {
+			return v, nil
+		}
+		return nil, fmt.Errorf("invalid string %s", raw)
+	case raw[0] == '[':
+		return parseTOMLArray(raw)
+	case raw[0] == '{':
+		return nil, fmt.Errorf("inline tables are not supported")
+	}
+
+	num := strings.Replace(raw, "_", "", -1)
+	if d := strings.TrimLeft(num, "+-"); len(d) > 1 && d[0] == '0' && d[1] >= '0' && d[1] <= '9' { // 不将 010 当作八进制
+		return nil, fmt.Errorf("leading zeros are not allowed: %s", raw)
+	}
+	if n, err := strconv.ParseInt(num, 0, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(num, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("unsupported value %s", raw)
+}
+
+func parseTOMLArray(raw string) ([]interface{}, error) {
+	if !tomlArrayClosed(raw) {
+		return nil, fmt.Errorf("unterminated array %s", raw)
+	}
+	body := strings.TrimSpace(raw[1 : len(raw)-1])
+	arr := make([]interface{}, 0)
+	items := splitTOMLArray(body)
+	for i, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" && i == len(items)-1 { // 允许末尾逗号
+			continue
+		}
+		if item == "" {
+			return nil, fmt.Errorf("empty element in array %s", raw)
+		}
+		v, err := parseTOMLValue(item)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+	}
+	return arr, nil
+}
+
+// 按顶层逗号切分数组元素，忽略字符串与嵌套数组中的逗号
+func splitTOMLArray(body string) []string {
+	var items []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, body[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, body[start:])
+}
+
+func tomlArrayClosed(raw string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+	return depth == 0
+}
+
+// 去掉不在字符串内的 # 注释
+func stripTOMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// 解析 bare key 或引号 key，不支持 a.b = 1 形式的 dotted key
+func parseTOMLKey(raw string) (string, error) {
+	if raw == "" {
+		return "", fmt.Errorf("missing key")
+	}
+	if raw[0] == '"' || raw[0] == '\'' {
+		if len(raw) < 2 || raw[len(raw)-1] != raw[0] {
+			return "", fmt.Errorf("unterminated key %s", raw)
+		}
+		key := raw[1 : len(raw)-1]
+		if strings.ContainsAny(key, "\"'\\.") { // 转义及含 . 的引号 key 易与 dotted key 混淆
+			return "", fmt.Errorf("unsupported quoted key %s", raw)
+		}
+		return key, nil
+	}
+	for _, c := range raw {
+		switch {
+		case c == '.':
+			return "", fmt.Errorf("dotted key %s is not supported, use a [table]", raw)
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+		default:
+			return "", fmt.Errorf("invalid key %q", raw)
+		}
+	}
+	return raw, nil
+}
@@ -0,0 +1,284 @@
+//go:build linux
+// +build linux
+
+package tron
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"logx"
+	"sync"
+	"syscall"
+)
+
+const (
+	eventLoopSupported = true
+	EVENT_READ_SIZE    = 64 * 1024 // poller 单次读取的最大字节数
+	EVENT_BATCH        = 128       // 单次 epoll_wait 处理的最大事件数
+)
+
+// 一组 poller，连接按 fd 分配到固定的 poller
+type eventLoop struct {
+	pollers []*poller
+}
+
+func newEventLoop(n int) (*eventLoop, error) {
+	loop := &eventLoop{}
+	for i := 0; i < n; i++ {
+		p, err := newPoller()
+		if err != nil {
+			loop.close()
+			return nil, err
+		}
+		loop.pollers = append(loop.pollers, p)
+		go p.run()
+	}
+	return loop, nil
+}
+
+// 注册 worker 的连接，此后由 poller 读包并分发给 handler
+func (loop *eventLoop) add(worker *Client) error {
+	sc, ok := worker.conn.(syscall.Conn)
+	if !ok {
+		return errors.New("connection has no file descriptor")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	fd := -1
+	if err := raw.Control(func(f uintptr) { fd = int(f) }); err != nil {
+		return err
+	}
+
+	p := loop.pollers[fd%len(loop.pollers)]
+	ec := &eventConn{fd: fd, raw: raw, worker: worker}
+	worker.session.detach = func() { p.remove(ec) }
+	return p.add(ec)
+}
+
+// 事件循环上的连接数
+func (loop *eventLoop) size() int {
+	n := 0
+	for _, p := range loop.pollers {
+		p.lock.Lock()
+		n += len(p.conns)
+		p.lock.Unlock()
+	}
+	return n
+}
+
+func (loop *eventLoop) close() {
+	for _, p := range loop.pollers {
+		p.close()
+	}
+}
+
+// poller 管理的连接
+type eventConn struct {
+	fd      int
+	raw     syscall.RawConn
+	worker  *Client
+	pending []byte // 尚未凑成完整包的数据，无残留时为 nil
+}
+
+type poller struct {
+	epfd    int
+	wakeR   int // 写入 wakeW 以唤醒 epoll_wait 退出
+	wakeW   int
+	lock    sync.Mutex
+	conns   map[int]*eventConn
+	closing bool
+	buf     []byte        // 所有连接共享的读缓冲
+	rd      bytes.Reader  // 将读到的数据交给 Codec.ReadPacket 拆包
+	br      *bufio.Reader // 包装 rd
+}
+
+func newPoller() (*poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	var wake [2]int
+	if err := syscall.Pipe2(wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(wake[0])}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, wake[0], &ev); err != nil {
+		syscall.Close(epfd)
+		syscall.Close(wake[0])
+		syscall.Close(wake[1])
+		return nil, err
+	}
+	p := &poller{
+		epfd:  epfd,
+		wakeR: wake[0],
+		wakeW: wake[1],
+		conns: make(map[int]*eventConn),
+		buf:   make([]byte, EVENT_READ_SIZE),
+	}
+	p.br = bufio.NewReader(&p.rd)
+	return p, nil
+}
+
+func (p *poller) add(ec *eventConn) error {
+	p.lock.Lock()
+	p.conns[ec.fd] = ec
+	p.lock.Unlock()
+
+	// 水平触发，每次事件读一次，连接间公平
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(ec.fd)}
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, ec.fd, &ev)
+}
+
+// 在连接关闭前调用，避免 fd 被新连接复用后错配
+func (p *poller) remove(ec *eventConn) {
+	p.lock.Lock()
+	if p.conns[ec.fd] == ec {
+		delete(p.conns, ec.fd)
+	}
+	p.lock.Unlock()
+	syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, ec.fd, nil)
+}
+
+func (p *poller) close() {
+	p.lock.Lock()
+	p.closing = true
+	p.lock.Unlock()
+	syscall.Write(p.wakeW, []byte{0})
+}
+
+func (p *poller) run() {
+	defer func() {
+		syscall.Close(p.epfd)
+		syscall.Close(p.wakeR)
+		syscall.Close(p.wakeW)
+	}()
+
+	events := make([]syscall.EpollEvent, EVENT_BATCH)
+	for {
+		n, err := syscall.EpollWait(p.epfd, events, -1)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			logx.Error("epoll wait: %v", err)
+			return
+		}
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == p.wakeR {
+				p.lock.Lock()
+				closing := p.closing
+				p.lock.Unlock()
+				if closing {
+					return
+				}
+				continue
+			}
+			p.lock.Lock()
+			ec := p.conns[fd]
+			p.lock.Unlock()
+			if ec != nil {
+				p.read(ec)
+			}
+		}
+	}
+}
+
+// 读一次数据，拆出其中完整的包并分发
+func (p *poller) read(ec *eventConn) {
+	session := ec.worker.session
+	var n int
+	var rerr error
+	err := ec.raw.Read(func(fd uintptr) bool {
+		n, rerr = syscall.Read(int(fd), p.buf)
+		return true // 不等待可读，未就绪时返回 EAGAIN
+	})
+	if err == nil {
+		err = rerr
+	}
+	if err == syscall.EAGAIN || err == syscall.EINTR { // fd 已被复用或事件已过期
+		return
+	}
+	if err != nil || n == 0 { // 另一端关闭或连接出错
+		session.Close()
+		return
+	}
+
+	data := p.buf[:n]
+	if ec.pending != nil {
+		ec.pending = append(ec.pending, data...)
+		data = ec.pending
+	}
+	rest, err := p.unpack(ec, data)
+	if err != nil {
+		if IsProtocolError(err) { // 对端数据不合法，仅关闭当前会话
+			logx.Error("%s -> %s %v", session.LocalAddr(), session.RemoteAddr(), err)
+		}
+		session.Close()
+		return
+	}
+	if len(rest) == 0 {
+		ec.pending = nil // 空闲连接不保留缓冲
+		return
+	}
+	ec.pending = append(ec.pending[:0], rest...) // rest 可能与 pending 重叠，copy 可正确处理
+}
+
+// 用 Codec 从 data 中拆出完整的包，返回不足一个包的剩余数据
+func (p *poller) unpack(ec *eventConn, data []byte) ([]byte, error) {
+	session := ec.worker.session
+	p.rd.Reset(data)
+	p.br.Reset(&p.rd)
+	pos := 0
+	for !session.IsClosed() {
+		b, err := session.codec.ReadPacket(p.br)
+		if err == io.EOF || err == io.ErrUnexpectedEOF { // 等待后续数据
+			return data[pos:], nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		pos = len(data) - p.rd.Len() - p.br.Buffered()
+
+		pkt, err := session.codec.UnmarshalPacket(b)
+		if err == nil {
+			pkt, err = session.inbound(pkt)
+		}
+		if err != nil && session.dropCorrupted(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pkt == nil {
+			continue
+		}
+		if pkt.Header.Flags&FLAG_BATCH != 0 { // 批量帧拆开后逐个限速
+			items, err := unbatch(pkt)
+			if err != nil {
+				return nil, err
+			}
+			for _, item := range items {
+				ec.deliver(item)
+			}
+			continue
+		}
+		ec.deliver(pkt)
+	}
+	return nil, nil
+}
+
+func (ec *eventConn) deliver(pkt *Packet) {
+	session := ec.worker.session
+	if session.limit != nil && !session.limit(pkt) {
+		pkt.Release() // 被限速丢弃
+		return
+	}
+	session.touch()
+	ec.worker.dispatch(pkt)
+}
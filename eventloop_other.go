@@ -0,0 +1,26 @@
+//go:build !linux
+// +build !linux
+
+package tron
+
+import (
+	"errors"
+)
+
+const eventLoopSupported = false
+
+type eventLoop struct{}
+
+func newEventLoop(n int) (*eventLoop, error) {
+	return nil, errors.New("event loop is not supported on this platform")
+}
+
+func (loop *eventLoop) add(worker *Client) error {
+	return errors.New("event loop is not supported on this platform")
+}
+
+func (loop *eventLoop) size() int {
+	return 0
+}
+
+func (loop *eventLoop) close() {}
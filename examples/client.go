@@ -1,30 +1,25 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"net"
 	"time"
 	"tron"
 )
 
 func main() {
-	addr, err := net.ResolveTCPAddr("tcp4", "localhost:8080")
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
 	clientConf := tron.NewDefaultConf(1 * time.Minute)
 	r := tron.NewReconnectTaskManager(5*time.Second, 3)
 	manager := tron.NewClientsManager(r)
-	conn, err := net.DialTCP("tcp4", nil, addr)
+	cli, err := tron.Dial(context.Background(), "localhost:8080",
+		tron.WithClientConfig(clientConf),
+		tron.WithClientHandler(packHandler),
+		tron.WithDialTimeout(3*time.Second),
+	)
 	if err != nil {
 		panic(err)
 	}
 
-	codec := tron.NewDefaultCodec()
-	cli := tron.NewClient(conn, clientConf, codec, packHandler)
-	cli.ReadWriteAndHandle()
 	g := tron.NewClientsGroup("add-service", "add-service")
 	manager.Add(g, cli)
 
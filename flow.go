@@ -0,0 +1,181 @@
+package tron
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// 流控窗口均以数据字节数计
+const (
+	INITIAL_WINDOW        = 64 * 1024   // 双方默认的初始窗口，更大的窗口建立后以 window 包补足
+	MAX_WINDOW            = 1 << 30     // 窗口上限，增量以 4 字节编码
+	DEFAULT_STREAM_WINDOW = 256 * 1024  // 单个流的接收窗口
+	DEFAULT_CONN_WINDOW   = 1024 * 1024 // 单个连接上所有流的接收窗口
+)
+
+var ERR_WINDOW_EXCEEDED = errors.New("peer exceeded receive window")
+
+// 发送方剩余的信用，耗尽时 acquire 阻塞，直到对端发回 window 包
+type flow struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	window int64
+	closed bool
+}
+
+func newFlow(window int64) *flow {
+	f := &flow{window: window}
+	f.cond = sync.NewCond(&f.lock)
+	return f
+}
+
+// 窗口为正即可发送，消息大于剩余窗口时允许透支，避免大消息永远无法发出
+// flow 关闭时返回 false
+func (f *flow) acquire(n int64) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for f.window <= 0 && !f.closed {
+		f.cond.Wait()
+	}
+	if f.closed {
+		return false
+	}
+	f.window -= n
+	return true
+}
+
+func (f *flow) grant(n int64) {
+	f.lock.Lock()
+	f.window += n
+	f.lock.Unlock()
+	f.cond.Broadcast()
+}
+
+// 唤醒等待者，之后 acquire 均失败
+func (f *flow) close() {
+	f.lock.Lock()
+	f.closed = true
+	f.lock.Unlock()
+	f.cond.Broadcast()
+}
+
+// 接收方已处理的字节数，累计到窗口一半时返回增量发回对端
+// 同时记录对端占用的窗口，对端不理会窗口时可以发现
+type credit struct {
+	lock     sync.Mutex
+	consumed int64
+	extra    int64 // 初始窗口之外开放的字节数
+	used     int64 // 已收到且未发回增量的字节数
+}
+
+// 收到 n 字节，与 flow.acquire 相同允许最后一条消息透支
+// 窗口耗尽后对端仍发送时返回 false
+func (c *credit) receive(n int64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.used >= INITIAL_WINDOW+c.extra {
+		return false
+	}
+	c.used += n
+	return true
+}
+
+// 补足初始窗口
+func (c *credit) grow(n int64) {
+	c.lock.Lock()
+	c.extra += n
+	c.lock.Unlock()
+}
+
+func (c *credit) consume(n int64, window int) int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.consumed += n
+	if c.consumed < int64(window)/2 {
+		return 0
+	}
+	inc := c.consumed
+	c.consumed = 0
+	c.used -= inc
+	return inc
+}
+
+// 窗口增量包，流上的增量 seq 为流 id，连接的增量 seq 为 SEQ_WINDOW
+func NewWindowPacket(seq int32, inc int64) *Packet {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(inc))
+	p := NewRespPacket(seq, data)
+	p.Header.Flags = FLAG_WINDOW
+	if seq != SEQ_WINDOW {
+		p.Header.Flags |= FLAG_STREAM
+	}
+	return p
+}
+
+func parseWindow(data []byte) int64 {
+	if len(data) != 4 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint32(data))
+}
+
+// 对端实现了流控，发送需占用窗口，接收需发回增量
+func (s *Session) flowControlled() bool {
+	return s.PeerFlags()&FLAG_WINDOW != 0
+}
+
+// 协商完成后补足连接的接收窗口
+func (s *Session) growConnWindow() {
+	if s.flowControlled() && s.conf.ConnWindow > INITIAL_WINDOW {
+		inc := int64(s.conf.ConnWindow - INITIAL_WINDOW)
+		s.recvCredit.grow(inc)
+		s.sendCredit(SEQ_WINDOW, inc)
+	}
+}
+
+// 流上的数据到达时占用连接窗口，交给流后即归还，流自身的积压由流窗口限制
+// 对端超出窗口发送时返回 error，需关闭会话
+func (s *Session) consumeConn(n int) error {
+	if !s.flowControlled() {
+		return nil
+	}
+	if !s.recvCredit.receive(int64(n)) {
+		return &ProtocolError{fmt.Errorf("%w: connection", ERR_WINDOW_EXCEEDED)}
+	}
+	if inc := s.recvCredit.consume(int64(n), s.conf.ConnWindow); inc > 0 {
+		s.sendCredit(SEQ_WINDOW, inc)
+	}
+	return nil
+}
+
+// 发回窗口增量，不阻塞调用方
+// 队列已满时按 seq 合并到 credits，由写协程发完一批后重试
+func (s *Session) sendCredit(seq int32, inc int64) {
+	s.creditLock.Lock()
+	defer s.creditLock.Unlock()
+	if s.credits == nil {
+		s.credits = make(map[int32]int64)
+	}
+	s.credits[seq] += inc
+	s.flushCredits()
+}
+
+// 尽量将合并的增量写入队列，事件循环模式下控制包不受队列上限约束，调用方持有 creditLock
+func (s *Session) flushCredits() {
+	for seq, inc := range s.credits {
+		if err := s.enqueue(NewWindowPacket(seq, inc), PRIORITY_CONTROL, s.direct); err != nil {
+			return
+		}
+		delete(s.credits, seq)
+	}
+}
+
+func (s *Session) retryCredits() {
+	s.creditLock.Lock()
+	if len(s.credits) > 0 {
+		s.flushCredits()
+	}
+	s.creditLock.Unlock()
+}
@@ -0,0 +1,87 @@
+package tron
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newFlowClient(t *testing.T, opts ...ConfigOption) *Client {
+	conf, err := BuildConfig(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, peer := net.Pipe()
+	t.Cleanup(func() { peer.Close() })
+	cli := NewClient(c, conf, NewDefaultCodec(), nil)
+	atomic.StoreUint32(&cli.session.peerFlags, RECV_FLAGS) // 视为已协商
+	return cli
+}
+
+func streamPacket(id int32, flags uint32, data []byte) *Packet {
+	p := NewRespPacket(id, data)
+	p.Header.Flags = FLAG_STREAM | flags
+	return p
+}
+
+// 对端不理会流窗口持续发送时关闭会话，未读取的消息不会无限积压
+func TestStreamRecvWindowExceeded(t *testing.T) {
+	cli := newFlowClient(t, WithStreamWindow(INITIAL_WINDOW))
+	block := make(chan struct{})
+	defer close(block)
+	cli.streamHandler = func(st *Stream) { <-block } // 从不读取
+	cli.session.growConnWindow()
+
+	cli.dispatch(streamPacket(1, FLAG_STREAM_OPEN, nil))
+	data := make([]byte, INITIAL_WINDOW/4)
+	for i := 0; i < 4; i++ {
+		cli.dispatch(streamPacket(1, 0, data))
+	}
+	if cli.IsClosed() {
+		t.Fatal("session closed within window")
+	}
+	cli.dispatch(streamPacket(1, 0, data))
+	if !cli.IsClosed() {
+		t.Fatal("session not closed after peer exceeded stream window")
+	}
+}
+
+func TestConnRecvWindowExceeded(t *testing.T) {
+	cli := newFlowClient(t)
+	s := cli.session
+	if err := s.consumeConn(INITIAL_WINDOW); err != nil {
+		t.Fatalf("within window: %v", err)
+	}
+	if err := s.consumeConn(1); !errors.Is(err, ERR_WINDOW_EXCEEDED) || !IsProtocolError(err) {
+		t.Fatalf("want window exceeded protocol error, got %v", err)
+	}
+}
+
+// 写队列已满时归还窗口不阻塞，增量合并后在队列有空位时发出
+func TestSendCreditCoalesced(t *testing.T) {
+	cli := newFlowClient(t, WithWriteChanSize(1), WithConnWindow(2*INITIAL_WINDOW))
+	s := cli.session
+	s.Write(NewHelloPacket(HELLO_OFFER, 0)) // 占满 control 队列
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 4; i++ {
+			s.consumeConn(INITIAL_WINDOW / 2) // 每次均达到窗口一半，发回增量
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("consumeConn blocked on full write queue")
+	}
+
+	<-s.lanes[PRIORITY_CONTROL]
+	s.retryCredits()
+	p := <-s.lanes[PRIORITY_CONTROL]
+	if p.Header.Seq != SEQ_WINDOW || parseWindow(p.Data) != 2*INITIAL_WINDOW {
+		t.Fatalf("want coalesced window %d, got seq %d inc %d", 2*INITIAL_WINDOW, p.Header.Seq, parseWindow(p.Data))
+	}
+}
@@ -0,0 +1,177 @@
+package tron
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+const (
+	FRAG_ID_LEN           = 4                // 分片数据前的消息 id
+	MAX_MESSAGE_LEN       = 64 * 1024 * 1024 // 重组后的最大长度
+	MAX_PARTIAL           = 256              // 单个会话同时重组中的最大消息数
+	DEFAULT_PARTIAL_BYTES = 8 * 1024 * 1024  // 单个会话重组中的消息的默认总字节数上限
+)
+
+var ERR_MESSAGE_TOO_LARGE = errors.New("reassembled message too large")
+
+// 发送中的大包，每批只发出一片，与其他包交错
+type fragment struct {
+	p    *Packet
+	id   uint32
+	off  int
+	held []*Packet // 之后写入的同 seq 的包，分片发完后再按序发出
+}
+
+// 对端支持且数据超过 FragmentSize 时分片发送
+func (s *Session) fragmentable(p *Packet, peer uint32) bool {
+	return s.conf.FragmentSize > 0 && len(p.Data) > s.conf.FragmentSize && peer&FLAG_FRAG != 0
+}
+
+func (s *Session) newFragment(p *Packet) *fragment {
+	return &fragment{p: p, id: atomic.AddUint32(&s.fragID, 1)}
+}
+
+func (f *fragment) done() bool {
+	return f.off >= len(f.p.Data)
+}
+
+// 取出下一片，数据为消息 id + 分片内容，buffer 来自池中
+func (f *fragment) next(size int) *Packet {
+	end := f.off + size
+	if end > len(f.p.Data) {
+		end = len(f.p.Data)
+	}
+	chunk := getBuf(FRAG_ID_LEN + end - f.off)
+	binary.BigEndian.PutUint32(chunk, f.id)
+	copy(chunk[FRAG_ID_LEN:], f.p.Data[f.off:end])
+	f.off = end
+
+	h := *f.p.Header
+	h.Flags |= FLAG_FRAG
+	if f.done() {
+		h.Flags |= FLAG_FRAG_END
+	}
+	h.DataLen = int32(len(chunk))
+	return &Packet{Header: &h, Data: chunk, buf: chunk}
+}
+
+// 编码一批待发送的包：压缩、校验，大包拆为分片
+// 新的大包加入发送队列，所有发送中的大包各追加一片
+// 同一 seq 上的包保持写入顺序，如流的 FIN 不会先于前一条消息的剩余分片
+func (s *Session) frame(batch []*Packet) []*Packet {
+	frames := s.frames[:0]
+	peer := s.PeerFlags()
+	for _, p := range batch {
+		p = s.outbound(p, peer)
+		if f := s.sending(p); f != nil {
+			f.held = append(f.held, p)
+			continue
+		}
+		if s.fragmentable(p, peer) {
+			s.frags = append(s.frags, s.newFragment(p))
+			continue
+		}
+		frames = append(frames, p)
+	}
+
+	n := 0
+	var next []*fragment // 由排队的包新建的分片，下一批开始发送
+	for _, f := range s.frags {
+		frames = append(frames, f.next(s.conf.FragmentSize))
+		if !f.done() {
+			s.frags[n] = f
+			n++
+			continue
+		}
+		for i, p := range f.held {
+			if s.fragmentable(p, peer) { // 其后的包继续排在新的大包之后
+				nf := s.newFragment(p)
+				nf.held = f.held[i+1:]
+				next = append(next, nf)
+				break
+			}
+			frames = append(frames, p)
+		}
+	}
+	for i := n; i < len(s.frags); i++ {
+		s.frags[i] = nil
+	}
+	s.frags = append(s.frags[:n], next...)
+	s.frames = frames
+	return frames
+}
+
+// 返回同 seq 上分片发送中的大包
+// 窗口包与数据的先后无关，不必等待
+func (s *Session) sending(p *Packet) *fragment {
+	if p.Header.Flags&FLAG_WINDOW != 0 {
+		return nil
+	}
+	for _, f := range s.frags {
+		if f.p.Header.Seq == p.Header.Seq {
+			return f
+		}
+	}
+	return nil
+}
+
+// 重组分片，消息未收全时返回 nil
+func (s *Session) reassemble(p *Packet) (*Packet, error) {
+	if len(p.Data) < FRAG_ID_LEN {
+		p.Release()
+		return nil, fmt.Errorf("%w: fragment without message id", ERR_PACKET_LEN_INVALID)
+	}
+	id := binary.BigEndian.Uint32(p.Data)
+	if s.partial == nil {
+		s.partial = make(map[uint32][]byte)
+	}
+	msg, ok := s.partial[id]
+	if !ok && len(s.partial) >= MAX_PARTIAL {
+		p.Release()
+		return nil, fmt.Errorf("too many partial messages, limit %d", MAX_PARTIAL)
+	}
+	if len(msg)+len(p.Data)-FRAG_ID_LEN > MAX_MESSAGE_LEN {
+		p.Release()
+		return nil, fmt.Errorf("%w: exceeds %d bytes", ERR_MESSAGE_TOO_LARGE, MAX_MESSAGE_LEN)
+	}
+	n := len(p.Data) - FRAG_ID_LEN
+	if limit := s.partialLimit(); s.partialLen+n > limit {
+		p.Release()
+		return nil, fmt.Errorf("%w: partial messages exceed %d bytes", ERR_MESSAGE_TOO_LARGE, limit)
+	}
+	msg = append(msg, p.Data[FRAG_ID_LEN:]...)
+	s.partialLen += n
+	h := *p.Header
+	p.Release()
+
+	if h.Flags&FLAG_FRAG_END == 0 {
+		s.partial[id] = msg
+		return nil, nil
+	}
+	delete(s.partial, id)
+	s.partialLen -= len(msg)
+	h.Flags &^= FLAG_FRAG | FLAG_FRAG_END
+	h.DataLen = int32(len(msg))
+	return &Packet{Header: &h, Data: msg}, nil
+}
+
+func (s *Session) partialLimit() int {
+	if s.conf.PartialBytes > 0 {
+		return s.conf.PartialBytes
+	}
+	return DEFAULT_PARTIAL_BYTES
+}
+
+// 分片帧加上包头、flags、消息 id 与校验和后不能超过 codec 的最大包长度
+// 只能校验 DefaultCodec，对端使用更小的上限时仍会拒绝
+func validateFragmentSize(errs *ConfigError, conf *Config, codec Codec) {
+	c, ok := codec.(*DefaultCodec)
+	if !ok || conf == nil || conf.FragmentSize <= 0 {
+		return
+	}
+	if max := int(c.maxPacketLen) - HEADER_LEN - FLAGS_LEN - FRAG_ID_LEN - CRC_LEN; conf.FragmentSize > max {
+		errs.Addf("FragmentSize %d exceeds %d allowed by codec max packet length %d", conf.FragmentSize, max, c.maxPacketLen)
+	}
+}
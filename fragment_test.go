@@ -0,0 +1,52 @@
+package tron
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func fragPacket(id uint32, n int, end bool) *Packet {
+	data := make([]byte, FRAG_ID_LEN+n)
+	binary.BigEndian.PutUint32(data, id)
+	h := &Header{Seq: int32(id), Flags: FLAG_FRAG, DataLen: int32(len(data))}
+	if end {
+		h.Flags |= FLAG_FRAG_END
+	}
+	return &Packet{Header: h, Data: data}
+}
+
+// 重组中的消息总字节数受限，完成的消息归还额度
+func TestReassemblePartialLimit(t *testing.T) {
+	s := &Session{conf: &Config{PartialBytes: 1024}}
+	steps := []struct {
+		id   uint32
+		n    int
+		end  bool
+		done int // 完成的消息长度，0 表示未完成
+		len  int // 之后 partialLen 的值
+	}{
+		{1, 600, false, 0, 600},
+		{2, 300, false, 0, 900},
+		{1, 100, true, 700, 300},
+		{3, 700, false, 0, 1000},
+		{2, 24, true, 324, 700},
+	}
+	for i, st := range steps {
+		p, err := s.reassemble(fragPacket(st.id, st.n, st.end))
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if (p == nil) != (st.done == 0) || p != nil && len(p.Data) != st.done {
+			t.Fatalf("step %d: got %v, want message of %d bytes", i, p, st.done)
+		}
+		if s.partialLen != st.len {
+			t.Fatalf("step %d: partialLen %d, want %d", i, s.partialLen, st.len)
+		}
+	}
+
+	// 超出上限时拒绝，即使单个消息未超过 MAX_MESSAGE_LEN
+	if _, err := s.reassemble(fragPacket(4, 400, false)); !errors.Is(err, ERR_MESSAGE_TOO_LARGE) {
+		t.Fatalf("want ERR_MESSAGE_TOO_LARGE, got %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package tron
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// 本版本可接收的 flags，协商时告知对端
+const RECV_FLAGS = FLAG_DEFLATE | FLAG_GZIP | FLAG_CRC | FLAG_FRAG | FLAG_FRAG_END |
+	FLAG_STREAM | FLAG_STREAM_OPEN | FLAG_STREAM_FIN | FLAG_WINDOW |
+	FLAG_ONEWAY | FLAG_BATCH
+
+// 按需协商时等待 server 回复的默认时长
+const HELLO_TIMEOUT = 3 * time.Second
+
+// hello 包由 client 发起，server 回复，旧版本 server 原样回显的 offer 会被 client 忽略
+// client 收到回复后若将附带校验和，再以 confirm 告知 server
+const (
+	HELLO_OFFER   byte = 1
+	HELLO_ACCEPT  byte = 2
+	HELLO_CONFIRM byte = 3
+)
+
+var helloMagic = []byte("TRON")
+
+// 协商包，data 为 magic + kind + 可接收的 flags
+func NewHelloPacket(kind byte, flags uint32) *Packet {
+	data := make([]byte, len(helloMagic)+1+4)
+	n := copy(data, helloMagic)
+	data[n] = kind
+	binary.BigEndian.PutUint32(data[n+1:], flags)
+	return NewRespPacket(SEQ_HELLO, data)
+}
+
+// 追加本端此后发送的包必定附带的 flags，旧版本忽略多出的数据
+func newHelloPacket(kind byte, flags, sendFlags uint32) *Packet {
+	data := NewHelloPacket(kind, flags).Data
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], sendFlags)
+	return NewRespPacket(SEQ_HELLO, append(data, b[:]...))
+}
+
+// 旧版本的 hello 不带 sendFlags，视为 0
+func parseHello(data []byte) (kind byte, flags, sendFlags uint32, ok bool) {
+	n := len(helloMagic)
+	if len(data) < n+1+4 || !bytes.Equal(data[:n], helloMagic) {
+		return 0, 0, 0, false
+	}
+	if len(data) >= n+1+8 {
+		sendFlags = binary.BigEndian.Uint32(data[n+5:])
+	}
+	return data[n], binary.BigEndian.Uint32(data[n+1:]), sendFlags, true
+}
+
+// 需要对端支持的扩展
+func (c *Config) wantFlags() bool {
+	return c.Compression != COMPRESS_NONE || c.Checksum || c.FragmentSize > 0 || c.Batch
+}
+
+// 对端声明可接收的 flags，协商完成前为 0，只发送旧格式的包
+func (s *Session) PeerFlags() uint32 {
+	return atomic.LoadUint32(&s.peerFlags)
+}
+
+// client 发起协商，每个会话只发送一次
+// 只应在需要扩展时调用，旧版本 server 会将 hello 当作普通请求交给 handler
+func (s *Session) offer() {
+	if !atomic.CompareAndSwapInt32(&s.offered, 0, 1) {
+		return
+	}
+	if err := s.Write(NewHelloPacket(HELLO_OFFER, RECV_FLAGS)); err != nil {
+		fmt.Printf("session: send hello failed: %v\n", err)
+	}
+}
+
+// 发起协商并等待 server 回复，ctx 无截止时间时最多等待 HELLO_TIMEOUT
+func (s *Session) negotiate(ctx context.Context) error {
+	s.offer()
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, HELLO_TIMEOUT)
+		defer cancel()
+	}
+	select {
+	case <-s.accepted:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 协商后本端发送的包必定附带的 flags
+func (s *Session) sendFlags(peer uint32) uint32 {
+	if s.conf.Checksum && peer&FLAG_CRC != 0 {
+		return FLAG_CRC
+	}
+	return 0
+}
+
+// 处理 hello 包，发起过协商的一方只接受回复，另一方只回复 offer 与 confirm
+// 先保存对端 flags 再回复，回复之后写出的包均已按协商结果编码
+// 对端声明此后附带校验和时，不带校验和的包按校验失败处理
+func (s *Session) hello(p *Packet) {
+	kind, flags, sendFlags, ok := parseHello(p.Data)
+	if !ok {
+		return
+	}
+	offered := atomic.LoadInt32(&s.offered) == 1
+	switch {
+	case kind == HELLO_OFFER && !offered:
+		atomic.StoreUint32(&s.peerFlags, flags)
+		s.Write(newHelloPacket(HELLO_ACCEPT, RECV_FLAGS, s.sendFlags(flags)))
+		s.growConnWindow()
+	case kind == HELLO_ACCEPT && offered:
+		atomic.StoreUint32(&s.peerFlags, flags)
+		if sendFlags&FLAG_CRC != 0 {
+			atomic.StoreInt32(&s.peerCRC, 1)
+		}
+		if f := s.sendFlags(flags); f != 0 {
+			s.Write(newHelloPacket(HELLO_CONFIRM, RECV_FLAGS, f))
+		}
+		s.growConnWindow()
+		s.acceptOnce.Do(func() { close(s.accepted) })
+	case kind == HELLO_CONFIRM && !offered:
+		if sendFlags&FLAG_CRC != 0 {
+			atomic.StoreInt32(&s.peerCRC, 1)
+		}
+	}
+}
+
+// 读到包后的公共处理：协商、连接窗口、重组与解压，校验和已由 codec 验证
+// 返回 nil 表示包已被 session 消化，不再分发
+func (s *Session) inbound(p *Packet) (*Packet, error) {
+	if p.Header.Seq == SEQ_HELLO {
+		s.hello(p)
+		p.Release()
+		return nil, nil
+	}
+	if p.Header.Flags&FLAG_CRC == 0 && atomic.LoadInt32(&s.peerCRC) == 1 { // 标记位被篡改时 codec 不会校验
+		p.Release()
+		return nil, &ProtocolError{fmt.Errorf("%w: packet without checksum", ERR_CHECKSUM)}
+	}
+	if p.Header.Seq == SEQ_WINDOW {
+		s.sendFlow.grant(parseWindow(p.Data))
+		p.Release()
+		return nil, nil
+	}
+
+	flags := p.Header.Flags
+	if flags&^RECV_FLAGS != 0 {
+		p.Release()
+		return nil, &ProtocolError{fmt.Errorf("unknown header flags %#x", flags&^RECV_FLAGS)}
+	}
+	if flags&FLAG_FRAG != 0 { // 收全后再解压
+		var err error
+		if p, err = s.reassemble(p); err != nil || p == nil {
+			if err != nil {
+				err = &ProtocolError{err}
+			}
+			return nil, err
+		}
+		flags = p.Header.Flags
+	}
+	if flags&(FLAG_DEFLATE|FLAG_GZIP) != 0 {
+		data, err := decompress(flags, p.Data)
+		seq := p.Header.Seq
+		p.Release()
+		if err != nil {
+			return nil, &ProtocolError{err}
+		}
+		p = NewRespPacket(seq, data)
+		p.Header.Flags = flags
+	}
+	p.Header.Flags &^= FLAG_DEFLATE | FLAG_GZIP | FLAG_CRC | FLAG_FRAG_END
+	return p, nil
+}
+
+// 发送前按协商结果压缩、附加校验和，不修改调用方的包
+// peer 由调用方取一次，同一批包与其分片按相同的协商结果编码
+func (s *Session) outbound(p *Packet, peer uint32) *Packet {
+	if p.Header.Seq == SEQ_HELLO {
+		return p
+	}
+	if p.Header.Flags&FLAG_ONEWAY != 0 && peer&FLAG_ONEWAY == 0 { // 旧版本对端会因未知 flag 断开
+		h := *p.Header
+		h.Flags &^= FLAG_ONEWAY
+		p = &Packet{Header: &h, Data: p.Data}
+	}
+	flags := s.sendFlags(peer)
+	data := p.Data
+	if c := s.conf.Compression; c != COMPRESS_NONE && peer&c.flag() != 0 && len(data) >= s.conf.CompressMin {
+		if z := compress(c, data); z != nil { // 压缩有收益
+			data = z
+			flags |= c.flag()
+		}
+	}
+	if flags == 0 {
+		return p
+	}
+	h := *p.Header
+	h.Flags |= flags
+	h.DataLen = int32(len(data))
+	return &Packet{Header: &h, Data: data}
+}
@@ -1,12 +1,16 @@
 package tron
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 // 数据交互包
 // seq + dataLen + data
 type Packet struct {
 	Header *Header // 头部
 	Data   []byte  // 包数据
+	buf    []byte  // Data 所在的池化 buffer，Release 时归还
 }
 
 func (p Packet) Seq() int32 {
@@ -19,6 +23,44 @@ func (p Packet) String() (s string) {
 	return
 }
 
+// 处理完读到的包后归还其 buffer，之后不可再使用 Data
+// 不调用 Release 仅会失去复用，buffer 由 GC 回收
+func (p *Packet) Release() {
+	if p.buf != nil {
+		putBuf(p.buf)
+		p.buf = nil
+	}
+	p.Data = nil
+}
+
+// 负数 seq 不会被 SeqManager 分配，保留给控制包
+const (
+	SEQ_REQ      int32 = -1 // 待分配 seq 的请求包
+	SEQ_BUSY     int32 = -2 // server 连接数已满，随后关闭连接
+	SEQ_OVERLOAD int32 = -3 // 请求被限速丢弃
+	SEQ_HELLO    int32 = -4 // 建连后协商双方支持的 flags
+	SEQ_WINDOW   int32 = -5 // 连接的流控窗口增量
+	SEQ_ONEWAY   int32 = -6 // 单向消息，不分配 seq，接收方不回复
+	SEQ_BATCH    int32 = -7 // 批量帧，各项带有自己的 seq
+)
+
+// Header.Flags 各位的含义
+const (
+	FLAG_DEFLATE     uint32 = 1 << iota // 数据以 deflate 压缩
+	FLAG_GZIP                           // 数据以 gzip 压缩
+	FLAG_CRC                            // 数据后附带 4 字节 CRC32C 校验和
+	FLAG_FRAG                           // 大包的一个分片，数据以 4 字节消息 id 开头
+	FLAG_FRAG_END                       // 大包的最后一个分片
+	FLAG_STREAM                         // 流上的消息，seq 为流 id
+	FLAG_STREAM_OPEN                    // 打开流
+	FLAG_STREAM_FIN                     // 半关闭流，数据为结束状态
+	FLAG_WINDOW                         // 流控窗口增量，数据为 4 字节字节数
+	FLAG_ONEWAY                         // 单向消息，接收方不回复
+	FLAG_BATCH                          // 批量帧，数据为多个 seq + 长度 + 数据
+)
+
+var ERR_OVERLOAD = errors.New("request rejected: server overloaded")
+
 // 响应包直接使用 req packet 的 seq
 func NewRespPacket(seq int32, data []byte) *Packet {
 	h := &Header{
@@ -31,8 +73,26 @@ func NewRespPacket(seq int32, data []byte) *Packet {
 // 请求包 seq 需要重新处理
 func NewReqPacket(data []byte) *Packet {
 	h := &Header{
-		Seq:     -1,
+		Seq:     SEQ_REQ,
 		DataLen: int32(len(data)),
 	}
 	return &Packet{Header: h, Data: data}
 }
+
+// 单向消息不占用 seq，发送后无响应
+// 对端未协商 FLAG_ONEWAY 时发送前去掉该 flag，仅以 SEQ_ONEWAY 标记
+func NewOneWayPacket(data []byte) *Packet {
+	p := NewRespPacket(SEQ_ONEWAY, data)
+	p.Header.Flags = FLAG_ONEWAY
+	return p
+}
+
+// 是否为单向消息，handler 不应回复
+func (p Packet) OneWay() bool {
+	return p.Header.Seq == SEQ_ONEWAY
+}
+
+// server 拒绝连接前发送的控制包
+func NewBusyPacket() *Packet {
+	return NewRespPacket(SEQ_BUSY, []byte("server busy"))
+}
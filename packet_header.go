@@ -1,58 +1,78 @@
 package tron
 
 import (
-	"bytes"
 	"encoding/binary"
-	"io"
+	"fmt"
 )
 
 type Header struct {
 	Seq     int32
 	DataLen int32
+	Flags   uint32 // 扩展标志位，仅在对端协商支持后发送
 }
 
 const (
 	PACK_LEN   = 4     // packet 总长度
 	HEADER_LEN = 4 + 4 // seq  + dataLen
+	FLAGS_LEN  = 4     // 可选的 flags 扩展
+	CRC_LEN    = 4     // 可选的校验和
 )
 
-func MarshalHeader(h *Header) []byte {
-	packLen := PACK_LEN + HEADER_LEN + h.DataLen
-	buf := bytes.NewBuffer(make([]byte, 0, packLen))
-	write(buf, binary.BigEndian, int32(HEADER_LEN+h.DataLen)) // packet length
-	write(buf, binary.BigEndian, h.Seq)
-	write(buf, binary.BigEndian, h.DataLen)
-	return buf.Bytes()
-}
+// dataLen 最高位置 1 表示头部后紧跟 4 字节 flags，旧版本会将其视为非法长度
+const FLAGS_BIT = 1 << 31
 
-func UnmarshalHeader(b []byte) (*Header, error) {
-	r := bytes.NewReader(b)
-	h := &Header{}
-	if err := read(r, binary.BigEndian, &h.Seq); err != nil {
-		return nil, err
+// 头部编码后的长度
+func (h *Header) Len() int {
+	if h.Flags != 0 {
+		return HEADER_LEN + FLAGS_LEN
 	}
-	if err := read(r, binary.BigEndian, &h.DataLen); err != nil {
-		return nil, err
+	return HEADER_LEN
+}
+
+// 数据之后附带的字节数
+func (h *Header) TrailerLen() int {
+	if h.Flags&FLAG_CRC != 0 {
+		return CRC_LEN
 	}
-	return h, nil
+	return 0
 }
 
-// 读取 byte / []byte
-func read(r io.Reader, order binary.ByteOrder, data interface{}) error {
-	buf, ok := data.([]byte)
-	if ok {
-		_, err := io.ReadFull(r, buf)
-		return err
+// 拼接长度前缀与头部，直接按字节写入避免 binary.Write 的反射开销
+func MarshalHeader(h *Header) []byte {
+	return AppendHeader(make([]byte, 0, PACK_LEN+HEADER_LEN+FLAGS_LEN), h)
+}
+
+// 将长度前缀与头部追加到 dst 后，flags 为 0 时与旧格式完全一致
+func AppendHeader(dst []byte, h *Header) []byte {
+	var b [PACK_LEN + HEADER_LEN + FLAGS_LEN]byte
+	dataLen := uint32(h.DataLen)
+	if h.Flags != 0 {
+		dataLen |= FLAGS_BIT
+		binary.BigEndian.PutUint32(b[12:16], h.Flags)
 	}
-	return binary.Read(r, order, data)
+	binary.BigEndian.PutUint32(b[0:4], uint32(h.Len()+h.TrailerLen())+uint32(h.DataLen)) // packet length
+	binary.BigEndian.PutUint32(b[4:8], uint32(h.Seq))
+	binary.BigEndian.PutUint32(b[8:12], dataLen)
+	return append(dst, b[:PACK_LEN+h.Len()]...)
 }
 
-// 写入 byte / []byte
-func write(w io.Writer, order binary.ByteOrder, data interface{}) error {
-	buf, ok := data.([]byte)
-	if ok {
-		_, err := w.Write(buf)
-		return err
+func UnmarshalHeader(b []byte) (*Header, error) {
+	if len(b) < HEADER_LEN {
+		return nil, fmt.Errorf("%w: header needs %d bytes, got %d", ERR_PACKET_LEN_INVALID, HEADER_LEN, len(b))
 	}
-	return binary.Write(w, order, data)
+	dataLen := binary.BigEndian.Uint32(b[4:8])
+	h := &Header{
+		Seq:     int32(binary.BigEndian.Uint32(b[0:4])),
+		DataLen: int32(dataLen &^ FLAGS_BIT),
+	}
+	if dataLen&FLAGS_BIT != 0 {
+		if len(b) < HEADER_LEN+FLAGS_LEN {
+			return nil, fmt.Errorf("%w: header needs %d bytes, got %d", ERR_PACKET_LEN_INVALID, HEADER_LEN+FLAGS_LEN, len(b))
+		}
+		h.Flags = binary.BigEndian.Uint32(b[HEADER_LEN : HEADER_LEN+FLAGS_LEN])
+		if h.Flags == 0 {
+			return nil, fmt.Errorf("%w: empty flags extension", ERR_PACKET_LEN_INVALID)
+		}
+	}
+	return h, nil
 }
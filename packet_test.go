@@ -6,7 +6,7 @@ import (
 
 func TestRW(t *testing.T) {
 	codec := NewDefaultCodec()
-	oldPack := NewPacket([]byte("a"))
+	oldPack := NewReqPacket([]byte("a"))
 	b := codec.MarshalPacket(*oldPack)
 
 	if len(b) < PACK_LEN {
@@ -0,0 +1,159 @@
+package tron
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 写队列的优先级，每个优先级一个独立的队列
+type Priority int
+
+const (
+	PRIORITY_CONTROL Priority = iota // 协商、窗口与限速通知等控制包，总是最先发送
+	PRIORITY_HIGH                    // 心跳等对延迟敏感的包
+	PRIORITY_NORMAL                  // AsyncWrite 的默认优先级，即 WriteCh
+	PRIORITY_BULK                    // 大块数据，只在空闲时占满带宽
+	PRIORITY_LANES                   // 队列数
+)
+
+// 每轮调度各队列最多取出的包数，0 表示取空
+// 队列积压时带宽按 8:4:1 分给 high、normal、bulk，低优先级不会被饿死
+var laneWeights = [PRIORITY_LANES]int{0, 8, 4, 1}
+
+var ERR_INVALID_PRIORITY = errors.New("invalid priority")
+
+func (p Priority) String() string {
+	switch p {
+	case PRIORITY_CONTROL:
+		return "control"
+	case PRIORITY_HIGH:
+		return "high"
+	case PRIORITY_NORMAL:
+		return "normal"
+	case PRIORITY_BULK:
+		return "bulk"
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+func (p Priority) valid() bool {
+	return p >= PRIORITY_CONTROL && p < PRIORITY_LANES
+}
+
+// 未指定优先级时，协商、窗口与限速通知等控制包走 control 队列
+// 单向消息与批量帧等其余包走 normal 队列
+func priorityOf(p *Packet) Priority {
+	switch p.Header.Seq {
+	case SEQ_HELLO, SEQ_WINDOW, SEQ_BUSY, SEQ_OVERLOAD:
+		return PRIORITY_CONTROL
+	}
+	if p.Header.Flags&FLAG_WINDOW != 0 {
+		return PRIORITY_CONTROL
+	}
+	return PRIORITY_NORMAL
+}
+
+// 按优先级写入对应队列，队列已满时返回错误
+// 事件循环模式下所有包按序进入同一写队列，优先级不生效
+func (s *Session) WritePriority(p *Packet, prio Priority) error {
+	return s.enqueue(p, prio, false)
+}
+
+func (s *Session) enqueue(p *Packet, prio Priority, wait bool) error {
+	if !prio.valid() {
+		return ERR_INVALID_PRIORITY
+	}
+	if s.direct {
+		return s.queueDirect(p, prio, wait)
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return errors.New("conn closed")
+	}
+	lane := s.lanes[prio]
+	if !wait {
+		select {
+		case lane <- p:
+			return nil
+		default:
+			return errors.New("write channel full")
+		}
+	}
+	select {
+	case lane <- p:
+		return nil
+	case <-s.done:
+		return errors.New("conn closed")
+	}
+}
+
+// 取出优先级最高的已排队的包，均为空时阻塞等待任一队列
+// 队列已关闭时返回 false
+func (s *Session) next() (*Packet, bool) {
+	for _, lane := range s.lanes {
+		if p, ok := take(lane); p != nil || !ok {
+			return p, ok
+		}
+	}
+	var p *Packet
+	var ok bool
+	select {
+	case p, ok = <-s.lanes[PRIORITY_CONTROL]:
+	case p, ok = <-s.lanes[PRIORITY_HIGH]:
+	case p, ok = <-s.lanes[PRIORITY_NORMAL]:
+	case p, ok = <-s.lanes[PRIORITY_BULK]:
+	}
+	return p, ok
+}
+
+// 带超时地等待任一队列的包，超时返回 nil
+func (s *Session) nextBefore(deadline <-chan time.Time) (*Packet, bool) {
+	var p *Packet
+	var ok bool
+	select {
+	case p, ok = <-s.lanes[PRIORITY_CONTROL]:
+	case p, ok = <-s.lanes[PRIORITY_HIGH]:
+	case p, ok = <-s.lanes[PRIORITY_NORMAL]:
+	case p, ok = <-s.lanes[PRIORITY_BULK]:
+	case <-deadline:
+		return nil, true
+	}
+	return p, ok
+}
+
+// 按权重轮流从各队列取出已排队的包，直到批满或队列均为空
+// 队列已关闭时返回 false
+func (s *Session) schedule(batch []*Packet, max int) ([]*Packet, bool) {
+	for len(batch) < max {
+		took := false
+		for prio, lane := range s.lanes {
+			for n := 0; len(batch) < max && (laneWeights[prio] == 0 || n < laneWeights[prio]); n++ {
+				p, ok := take(lane)
+				if !ok {
+					return batch, false
+				}
+				if p == nil {
+					break
+				}
+				batch = append(batch, p)
+				took = true
+			}
+		}
+		if !took {
+			break
+		}
+	}
+	return batch, true
+}
+
+// 非阻塞取包，队列为空时返回 nil, true，已关闭时返回 nil, false
+func take(lane chan *Packet) (*Packet, bool) {
+	select {
+	case p, ok := <-lane:
+		return p, ok
+	default:
+		return nil, true
+	}
+}
@@ -0,0 +1,27 @@
+package tron
+
+import "testing"
+
+func TestPriorityOf(t *testing.T) {
+	window := NewRespPacket(0, nil)
+	window.Header.Flags = FLAG_WINDOW
+	cases := []struct {
+		name string
+		p    *Packet
+		want Priority
+	}{
+		{"hello", NewRespPacket(SEQ_HELLO, nil), PRIORITY_CONTROL},
+		{"window seq", NewRespPacket(SEQ_WINDOW, nil), PRIORITY_CONTROL},
+		{"busy", NewRespPacket(SEQ_BUSY, nil), PRIORITY_CONTROL},
+		{"overload", NewRespPacket(SEQ_OVERLOAD, nil), PRIORITY_CONTROL},
+		{"window flag", window, PRIORITY_CONTROL},
+		{"request", NewRespPacket(7, nil), PRIORITY_NORMAL},
+		{"one-way", NewOneWayPacket(nil), PRIORITY_NORMAL},
+		{"batch", NewRespPacket(SEQ_BATCH, nil), PRIORITY_NORMAL},
+	}
+	for _, c := range cases {
+		if got := priorityOf(c.p); got != c.want {
+			t.Fatalf("%s: got priority %v, want %v", c.name, got, c.want)
+		}
+	}
+}
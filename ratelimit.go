@@ -0,0 +1,103 @@
+package tron
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// 令牌桶限速参数
+type RateLimit struct {
+	Rate  float64 // 每秒产生的令牌数，0 则不限制
+	Burst int     // 桶容量，0 则取 Rate 向上取整
+}
+
+func (l RateLimit) Enabled() bool {
+	return l.Rate > 0
+}
+
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+// 令牌桶，每次调用时传入当前限速参数，热更新后立即按新参数生效
+type TokenBucket struct {
+	tokens float64
+	last   time.Time
+	lock   sync.Mutex
+}
+
+func NewTokenBucket(l RateLimit) *TokenBucket {
+	return &TokenBucket{
+		tokens: l.burst(),
+		last:   time.Now(),
+	}
+}
+
+func (b *TokenBucket) refill(l RateLimit, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * l.Rate
+	if burst := l.burst(); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
+
+// 有令牌则取走并返回 true，否则不消耗令牌
+func (b *TokenBucket) Allow(l RateLimit) bool {
+	if !l.Enabled() {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill(l, time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// 所有桶都有令牌时各取走一个并返回 true，否则均不消耗令牌
+// 共享的桶需在各调用中处于相同位置，避免加锁顺序不同而死锁
+func allowAll(buckets []*TokenBucket, limits []RateLimit) bool {
+	now := time.Now()
+	for i, b := range buckets {
+		if limits[i].Enabled() {
+			b.lock.Lock()
+			defer b.lock.Unlock()
+		}
+	}
+	for i, b := range buckets {
+		if !limits[i].Enabled() {
+			continue
+		}
+		b.refill(limits[i], now)
+		if b.tokens < 1 {
+			return false
+		}
+	}
+	for i, b := range buckets {
+		if limits[i].Enabled() {
+			b.tokens--
+		}
+	}
+	return true
+}
+
+// 预支一个令牌，返回需等待多久该令牌才可用
+func (b *TokenBucket) Reserve(l RateLimit) time.Duration {
+	if !l.Enabled() {
+		return 0
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill(l, time.Now())
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / l.Rate * float64(time.Second))
+}
@@ -0,0 +1,25 @@
+package tron
+
+import "testing"
+
+// 任一桶没有令牌时，其余桶的令牌不被消耗
+func TestAllowAllConsumesAtomically(t *testing.T) {
+	limit := RateLimit{Rate: 0.001, Burst: 2}
+	session, ip := NewTokenBucket(limit), NewTokenBucket(limit)
+	ip.tokens = 0
+	buckets := []*TokenBucket{session, ip}
+	limits := []RateLimit{limit, limit}
+	for i := 0; i < 3; i++ {
+		if allowAll(buckets, limits) {
+			t.Fatalf("round %d: allowed with empty ip bucket", i)
+		}
+	}
+	if session.tokens < 2 {
+		t.Fatalf("session bucket consumed by rejected packets: %v tokens left", session.tokens)
+	}
+
+	// 未启用的桶不参与检查
+	if !allowAll(buckets, []RateLimit{limit, {}}) || session.tokens >= 2 {
+		t.Fatalf("disabled ip bucket should not block, session tokens %v", session.tokens)
+	}
+}
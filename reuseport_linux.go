@@ -0,0 +1,32 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package tron
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+const (
+	reusePortSupported = true
+	soReusePort        = 0xf // syscall 包未导出 SO_REUSEPORT，mips 上取值不同，不在此文件构建
+)
+
+// 开启 SO_REUSEPORT 后由内核在多个 listener 间分配新连接
+func listenReusePort(network, addr string) (net.Listener, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	return lc.Listen(context.Background(), network, addr)
+}
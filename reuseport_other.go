@@ -0,0 +1,15 @@
+//go:build !linux || mips || mipsle || mips64 || mips64le
+// +build !linux mips mipsle mips64 mips64le
+
+package tron
+
+import (
+	"errors"
+	"net"
+)
+
+const reusePortSupported = false
+
+func listenReusePort(network, addr string) (net.Listener, error) {
+	return nil, errors.New("SO_REUSEPORT is not supported on this platform")
+}
@@ -35,16 +35,23 @@ func NewSeqManager(maxSeq int32) *SeqManager {
 }
 
 // 记录一个新的 seq 及其响应 channel
+// 负数 seq 保留给控制包，与 RemoveSeq 相同直接忽略，respCh 不会收到响应
 func (m *SeqManager) AddSeq(nextSeq int32, respCh chan interface{}) {
 	l, g := m.group(nextSeq)
+	if g == nil {
+		return
+	}
 	l.Lock()
 	g[nextSeq] = respCh
 	l.Unlock()
 }
 
-// 取出指定 seq 及其 channel 来发送响应
+// 取出指定 seq 及其 channel 来发送响应，负数 seq 不对应任何请求，直接忽略
 func (m *SeqManager) RemoveSeq(oldSeq int32, res interface{}) {
 	l, g := m.group(oldSeq)
+	if g == nil {
+		return
+	}
 	l.Lock()
 	defer l.Unlock()
 
@@ -55,13 +62,42 @@ func (m *SeqManager) RemoveSeq(oldSeq int32, res interface{}) {
 	}
 }
 
+// 放弃等待响应，如写入失败或等待超时，不向 respCh 发送任何值
+// seq 轮回后可能已分配给新的请求，仅在 channel 一致时删除
+func (m *SeqManager) DropSeq(seq int32, respCh chan interface{}) {
+	l, g := m.group(seq)
+	if g == nil {
+		return
+	}
+	l.Lock()
+	if g[seq] == respCh {
+		delete(g, seq)
+	}
+	l.Unlock()
+}
+
+// 等待响应中的 seq 数
+func (m *SeqManager) Pending() int {
+	n := 0
+	for i, l := range m.locks {
+		l.Lock()
+		n += len(m.groups[i])
+		l.Unlock()
+	}
+	return n
+}
+
 // 获取下一个可分配的 seq
 func (m *SeqManager) NextSeq() int32 {
-	next := atomic.AddInt32(&m.curSeq, 1)
-	return next % m.maxSeq // 轮回使用
+	next := uint32(atomic.AddInt32(&m.curSeq, 1)) // 计数溢出后仍为非负
+	return int32(next % uint32(m.maxSeq))         // 轮回使用
 }
 
+// 负数 seq 返回 nil
 func (m *SeqManager) group(seq int32) (lock *sync.Mutex, group map[int32]chan interface{}) {
+	if seq < 0 {
+		return
+	}
 	g := seq % MAX_CONCUR
 	lock = m.locks[g]
 	group = m.groups[g]
@@ -0,0 +1,34 @@
+package tron
+
+import (
+	"math"
+	"testing"
+)
+
+// 负数 seq 保留给控制包，不对应任何请求
+func TestSeqManagerIgnoresReservedSeq(t *testing.T) {
+	m := NewSeqManager(1024)
+	respCh := make(chan interface{}, 1)
+	for _, seq := range []int32{SEQ_REQ, SEQ_BUSY, SEQ_ONEWAY, math.MinInt32} {
+		m.AddSeq(seq, respCh)
+		m.RemoveSeq(seq, "resp")
+		m.DropSeq(seq, respCh)
+	}
+	if len(respCh) != 0 || m.Pending() != 0 {
+		t.Fatalf("reserved seq touched the manager: %d responses, %d pending", len(respCh), m.Pending())
+	}
+}
+
+// 计数溢出后分配的 seq 仍为非负
+func TestSeqManagerNextSeqWraps(t *testing.T) {
+	m := NewSeqManager(1000)
+	m.curSeq = math.MaxInt32 - 2
+	for i := 0; i < 5; i++ {
+		seq := m.NextSeq()
+		if seq < 0 || seq >= 1000 {
+			t.Fatalf("round %d: seq %d out of [0, 1000)", i, seq)
+		}
+		m.AddSeq(seq, make(chan interface{}, 1))
+		m.RemoveSeq(seq, nil)
+	}
+}
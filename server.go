@@ -1,21 +1,54 @@
 package tron
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"logx"
 	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 type Server struct {
-	address   string
-	handler   func(worker *Client, p *Packet)
-	conf      *Config
-	closed    bool
-	closeCh   chan struct{}
-	keepAlive time.Duration
-	codec     Codec
+	address    string
+	handler    func(worker *Client, p *Packet)
+	conf       *Config
+	closed     bool // 由 lock 保护
+	closeCh    chan struct{}
+	keepAlive  time.Duration
+	codec      Codec
+	lock       sync.RWMutex              // 保护可热更新的配置与会话表
+	workers    map[*Client]*LiveListener // 存活的连接会话及其来源
+	listeners  []*LiveListener
+	reusePort  int              // SO_REUSEPORT 监听的 listener 数量，0 则不开启
+	extra      []ListenerConfig // 额外的监听地址
+	readyCh    chan struct{}    // 全部 listener 开始 accept 后关闭
+	readyOnce  sync.Once
+	loops      sync.WaitGroup // 运行中的 accept 循环
+	acceptErr  error          // 首个导致 accept 循环退出的错误
+	limits     connLimits     // 连接数上限
+	perIP      map[string]int // remote ip -> 连接数
+	stats      ServerStats
+	slotCond   *sync.Cond   // 排队等待空闲连接名额
+	rates      atomic.Value // *rateLimits，读协程无锁读取
+	rateStats  rateStats
+	ipBuckets  map[string]*TokenBucket // remote ip -> 共享的限速令牌桶
+	eventLoops int                     // epoll poller 数量，0 则每个连接使用独立协程
+	loop       *eventLoop              // 事件循环，首次 serve 时创建
+	streams    func(st *Stream)        // 处理 client 打开的流
+	funcOpts   uint8                   // 已指定的 func 类 option，Reload 据此替换
 }
 
+// func 无法比较是否变化，Reload 时只要指定了对应 option 即替换
+const (
+	OPT_HANDLER uint8 = 1 << iota
+	OPT_STREAM_HANDLER
+	OPT_COMMAND_FUNC
+)
+
 func NewServer(addr string, conf *Config, serverCodec Codec, f func(worker *Client, p *Packet)) *Server {
 	s := &Server{
 		address:   addr,
@@ -25,44 +58,420 @@ func NewServer(addr string, conf *Config, serverCodec Codec, f func(worker *Clie
 		closeCh:   make(chan struct{}, 1),
 		keepAlive: 5 * time.Second,
 		codec:     serverCodec,
+		workers:   make(map[*Client]*LiveListener),
+		perIP:     make(map[string]int),
+		ipBuckets: make(map[string]*TokenBucket),
+		readyCh:   make(chan struct{}),
 	}
+	s.slotCond = sync.NewCond(&s.lock)
+	s.rates.Store(&rateLimits{commands: make(map[string]RateLimit)})
 	return s
 }
 
+type ServerOption func(s *Server)
+
+// 监听地址，热更新时修改需重启生效
+func WithServerAddr(addr string) ServerOption {
+	return func(s *Server) {
+		s.address = addr
+	}
+}
+
+func WithServerConfig(conf *Config) ServerOption {
+	return func(s *Server) {
+		s.conf = conf
+	}
+}
+
+func WithServerCodec(codec Codec) ServerOption {
+	return func(s *Server) {
+		s.codec = codec
+	}
+}
+
+func WithServerHandler(f func(worker *Client, p *Packet)) ServerOption {
+	return func(s *Server) {
+		s.handler = f
+		s.funcOpts |= OPT_HANDLER
+	}
+}
+
+// 处理 client 打开的流，未设置时 client 的 OpenStream 以 STATUS_UNIMPLEMENTED 结束
+// f 返回后未结束的流以 STATUS_OK 结束
+func WithServerStreamHandler(f func(st *Stream)) ServerOption {
+	return func(s *Server) {
+		s.streams = f
+		s.funcOpts |= OPT_STREAM_HANDLER
+	}
+}
+
+// 已接受连接的 TCP keepalive 间隔
+func WithServerKeepAlive(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.keepAlive = d
+	}
+}
+
+// 以 SO_REUSEPORT 在同一地址上打开 n 个 listener，各自 accept 后交给同一个 handler
+// 仅 Linux 支持
+func WithServerReusePort(n int) ServerOption {
+	return func(s *Server) {
+		s.reusePort = n
+	}
+}
+
+// 额外监听一个地址，与主地址共享 handler 与会话表
+func WithServerListener(lc ListenerConfig) ServerOption {
+	return func(s *Server) {
+		s.extra = append(s.extra, lc)
+	}
+}
+
+// 使用 opts 创建 server，未指定的配置与 codec 使用默认值
+func NewServerWith(addr string, opts ...ServerOption) (*Server, error) {
+	s := NewServer(addr, nil, nil, nil)
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.conf == nil {
+		conf, err := BuildConfig()
+		if err != nil {
+			return nil, err
+		}
+		s.conf = conf
+	}
+	if s.codec == nil {
+		s.codec = NewDefaultCodec()
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Server) validate() error {
+	errs := &ConfigError{}
+	if s.address == "" && len(s.extra) == 0 {
+		errs.Addf("server address is empty")
+	}
+	addrs := map[string]bool{s.address: true}
+	for i, lc := range s.extra {
+		if lc.Addr == "" {
+			errs.Addf("listener[%d] address is empty", i)
+		}
+		if addrs[lc.Addr] {
+			errs.Addf("listener[%d] address %q is duplicated", i, lc.Addr)
+		}
+		addrs[lc.Addr] = true
+		if lc.Config != nil {
+			errs.Merge(lc.Config.Validate())
+		}
+		conf, codec := s.conf, s.codec
+		if lc.Config != nil {
+			conf = lc.Config
+		}
+		if lc.Codec != nil {
+			codec = lc.Codec
+		}
+		validateFragmentSize(errs, conf, codec)
+		if lc.MaxConns < 0 {
+			errs.Addf("listener[%d] maxConns must not be negative, got %d", i, lc.MaxConns)
+		}
+	}
+	if s.keepAlive < 0 {
+		errs.Addf("server keepAlive must not be negative, got %v", s.keepAlive)
+	}
+	if s.reusePort < 0 {
+		errs.Addf("server reusePort must not be negative, got %d", s.reusePort)
+	}
+	if s.reusePort > 0 && !reusePortSupported {
+		errs.Addf("SO_REUSEPORT is not supported on this platform")
+	}
+	s.limits.validate(errs)
+	s.loadRates().validate(errs)
+	s.validateEventLoop(errs)
+	errs.Merge(s.conf.Validate())
+	validateFragmentSize(errs, s.conf, s.codec)
+	return errs.Err()
+}
+
 // 启动
 func (s *Server) ListenAndServe() error {
-	addr, err := net.ResolveTCPAddr("tcp4", s.address)
-	if err != nil {
+	type pending struct {
+		key string
+		l   net.Listener
+		lc  *ListenerConfig
+	}
+
+	// 全部监听成功后再开始接受连接
+	var listeners []pending
+	fail := func(err error) error {
 		logx.Error(err)
+		for _, p := range listeners {
+			p.l.Close()
+		}
 		return err
 	}
 
-	listener, err := net.ListenTCP("tcp4", addr)
+	if s.address != "" {
+		n := 1
+		if s.reusePort > 1 { // 多个 listener 各自 accept
+			n = s.reusePort
+		}
+		addr := s.address
+		for i := 0; i < n; i++ {
+			key := listenKey(s.address, i)
+			l, err := s.listen(key, addr)
+			if err != nil {
+				return fail(err)
+			}
+			listeners = append(listeners, pending{key: key, l: l})
+			if i == 0 { // 端口为 0 时其余 listener 绑定首个 listener 实际分配的端口
+				addr = l.Addr().String()
+			}
+		}
+	}
+
+	for i := range s.extra {
+		lc := &s.extra[i]
+		l, err := listenExtra(lc)
+		if err != nil {
+			return fail(err)
+		}
+		listeners = append(listeners, pending{key: lc.Addr, l: l, lc: lc})
+	}
+
+	if err := s.startEventLoop(); err != nil {
+		return fail(err)
+	}
+	for _, p := range listeners {
+		s.serve(p.key, p.l, p.lc)
+	}
+	s.readyOnce.Do(func() { close(s.readyCh) })
+	return nil
+}
+
+func (s *Server) listen(key, address string) (net.Listener, error) {
+	// 平滑重启时直接使用父进程交接的 listener
+	if l, err := inheritedListener(key); err != nil || l != nil {
+		return l, err
+	}
+
+	if s.reusePort > 0 {
+		return listenReusePort("tcp4", address)
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp4", address)
 	if err != nil {
-		logx.Error(err)
+		return nil, err
+	}
+	return net.ListenTCP("tcp4", addr)
+}
+
+func listenExtra(lc *ListenerConfig) (net.Listener, error) {
+	if l, err := inheritedListener(lc.Addr); err != nil || l != nil {
+		return l, err
+	}
+	return net.Listen(lc.network(), lc.Addr)
+}
+
+// 同一地址上的第 i 个 listener 的标识
+func listenKey(addr string, i int) string {
+	if i == 0 {
+		return addr
+	}
+	return fmt.Sprintf("%s#%d", addr, i)
+}
+
+// 在已有的 listener 上接受连接，如内存管道 LoopbackListener
+func (s *Server) Serve(listener net.Listener) error {
+	if err := s.startEventLoop(); err != nil {
 		return err
 	}
+	err := s.serve(listener.Addr().String(), listener, nil)
+	s.readyOnce.Do(func() { close(s.readyCh) })
+	return err
+}
+
+// key 为交接 listener 时使用的地址标识，lc 为 nil 时使用 server 的配置
+func (s *Server) serve(key string, listener net.Listener, lc *ListenerConfig) error {
+	s.lock.Lock()
+	liver := NewLiveListener(listener, s.closeCh, s.keepAlive)
+	liver.key = key
+	liver.conf = lc
+	s.listeners = append(s.listeners, liver)
+	s.lock.Unlock()
 
-	liver := NewLiveListener(listener, s.closeCh, 5*time.Second) // 保持 5s 连接
+	s.loops.Add(1)
 	go func(l *LiveListener) {
-		for !s.closed {
-			conn, err := l.AcceptTCP()
-			if err != nil {
-				logx.Error(err)
-				continue
+		defer s.loops.Done()
+		if err := s.acceptLoop(l); err != nil {
+			logx.Error(err)
+			s.lock.Lock()
+			if s.acceptErr == nil {
+				s.acceptErr = err
 			}
-
-			// 将连接分发给 server worker 处理
-			serverWorker := NewClient(conn, s.conf, s.codec, s.handler)
-			serverWorker.ReadWriteAndHandle()
+			s.lock.Unlock()
 		}
 	}(liver)
 	return nil
 }
 
+// 持续接受连接，临时错误退避重试，其余错误结束循环并返回
+func (s *Server) acceptLoop(l *LiveListener) error {
+	var delay time.Duration
+	for !s.isClosed() {
+		s.waitSlot(l)
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() || err == ERR_SERVER_CLOSED || errors.Is(err, net.ErrClosed) { // listener 已被关闭
+				return nil
+			}
+			if !isTemporary(err) {
+				return fmt.Errorf("accept on %s: %v", l.Addr(), err)
+			}
+
+			// 如 fd 耗尽，等待后重试
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			logx.Error(err)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		// 将连接分发给 server worker 处理
+		s.serveConn(conn, l)
+	}
+	return nil
+}
+
+func isTemporary(err error) bool {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.ENOMEM)
+}
+
+// 全部 listener 开始接受连接后关闭，用于等待 server 就绪
+func (s *Server) Ready() <-chan struct{} {
+	return s.readyCh
+}
+
+// 实际监听的地址，监听 ":0" 时可获取分配的端口
+func (s *Server) Addrs() []net.Addr {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		addrs = append(addrs, l.Addr())
+	}
+	return addrs
+}
+
+// 主监听地址，未监听时返回 nil
+func (s *Server) Addr() net.Addr {
+	addrs := s.Addrs()
+	if len(addrs) == 0 {
+		return nil
+	}
+	return addrs[0]
+}
+
+// 阻塞直到所有 accept 循环退出
+// 正常 Shutdown 返回 nil，否则返回首个导致 accept 失败的错误
+func (s *Server) Wait() error {
+	s.loops.Wait()
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.acceptErr
+}
+
+func (s *Server) serveConn(conn net.Conn, l *LiveListener) {
+	s.lock.Lock()
+	conf, codec := s.conf, s.codec
+	if lc := l.conf; lc != nil {
+		if lc.Config != nil {
+			conf = lc.Config
+		}
+		if lc.Codec != nil {
+			codec = lc.Codec
+		}
+		if lc.TLS != nil {
+			conn = tls.Server(conn, lc.TLS) // 首次读写时握手
+		}
+	}
+	if !s.admit(conn, l) {
+		s.lock.Unlock()
+		s.reject(conn, codec)
+		return
+	}
+	var serverWorker *Client
+	if s.loop != nil && eventLoopCapable(conn) {
+		serverWorker = newEventClient(conn, conf, codec, s.handler)
+	} else {
+		serverWorker = NewClient(conn, conf, codec, s.handler)
+	}
+	serverWorker.streamHandler = s.streams
+	serverWorker.worker = true
+	serverWorker.session.limit = s.newPacketLimiter(serverWorker, s.ipBucket(conn)).allow
+	serverWorker.OnClose(s.removeWorker)
+	serverWorker.session.watchIdle()
+	s.workers[serverWorker] = l
+	loop := s.loop
+	s.lock.Unlock()
+
+	if serverWorker.session.direct {
+		if err := loop.add(serverWorker); err != nil {
+			logx.Error("%s: event loop: %v", conn.RemoteAddr(), err)
+			serverWorker.session.Close()
+		}
+		return
+	}
+	serverWorker.ReadWriteAndHandle()
+}
+
+func (s *Server) removeWorker(worker *Client) {
+	s.lock.Lock()
+	if l, ok := s.workers[worker]; ok {
+		delete(s.workers, worker)
+		s.release(worker.conn, l)
+	}
+	s.stopEventLoopIfIdle()
+	s.lock.Unlock()
+}
+
+func (s *Server) isClosed() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.closed
+}
+
+// 当前存活的连接数
+func (s *Server) NumSessions() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.workers)
+}
+
 // 将服务器的连接关闭，不再接受新连接
 func (s *Server) Shutdown() {
+	s.lock.Lock()
 	s.closed = true
-	s.closeCh <- struct{}{} // 立刻停止
+	select {
+	case s.closeCh <- struct{}{}: // 立刻停止
+	default:
+	}
+	for _, l := range s.listeners {
+		l.Close() // 唤醒阻塞中的 Accept
+	}
+	s.stopEventLoopIfIdle() // 仍有连接时待最后一个连接关闭后停止
+	s.lock.Unlock()
+	s.slotCond.Broadcast() // 唤醒排队中的 accept 循环
 	logx.Debug("shutdown...")
 }
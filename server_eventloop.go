@@ -0,0 +1,63 @@
+package tron
+
+import (
+	"net"
+)
+
+// 以 n 个 epoll poller 读取所有连接，不再为每个连接常驻读、写、分发协程
+// 写由有待发数据时才启动的协程完成，慢的对端不会阻塞 poller
+// 适合大量空闲长连接，handler 与 Codec 的用法不变，仅 Linux 支持
+// TLS 及内存管道等没有 fd 的连接仍使用协程模式
+func WithServerEventLoop(n int) ServerOption {
+	return func(s *Server) {
+		s.eventLoops = n
+	}
+}
+
+func (s *Server) validateEventLoop(errs *ConfigError) {
+	if s.eventLoops < 0 {
+		errs.Addf("server eventLoops must not be negative, got %d", s.eventLoops)
+	}
+	if s.eventLoops == 0 {
+		return
+	}
+	if !eventLoopSupported {
+		errs.Addf("event loop is not supported on this platform")
+	}
+	if rates := s.loadRates(); rates.action == RATE_DELAY && rates.limited() { // 延迟读取会阻塞整个 poller
+		errs.Addf("rate action %s is not supported in event loop mode", RATE_DELAY)
+	}
+}
+
+// 首次 serve 时启动 poller
+func (s *Server) startEventLoop() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.eventLoops == 0 || s.loop != nil {
+		return nil
+	}
+	loop, err := newEventLoop(s.eventLoops)
+	if err != nil {
+		return err
+	}
+	s.loop = loop
+	return nil
+}
+
+// server 关闭且事件循环上已无连接时停止 poller，需持有 s.lock
+func (s *Server) stopEventLoopIfIdle() {
+	if s.loop == nil || !s.closed || s.loop.size() > 0 {
+		return
+	}
+	s.loop.close()
+	s.loop = nil
+}
+
+// 只有直接持有 fd 的连接可交给 poller
+func eventLoopCapable(conn net.Conn) bool {
+	switch conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package tron
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// 连接数超限时的处理方式
+type LimitPolicy int
+
+const (
+	LIMIT_REJECT LimitPolicy = iota // 直接关闭连接
+	LIMIT_BUSY                      // 发送 busy 控制包后关闭连接
+	LIMIT_QUEUE                     // 总数或 listener 超限时暂停 accept，由内核 backlog 排队；单 IP 超限仍直接关闭
+)
+
+func (p LimitPolicy) String() string {
+	switch p {
+	case LIMIT_REJECT:
+		return "reject"
+	case LIMIT_BUSY:
+		return "busy"
+	case LIMIT_QUEUE:
+		return "queue"
+	}
+	return fmt.Sprintf("LimitPolicy(%d)", int(p))
+}
+
+func ParseLimitPolicy(s string) (LimitPolicy, error) {
+	for _, p := range []LimitPolicy{LIMIT_REJECT, LIMIT_BUSY, LIMIT_QUEUE} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown limit policy %q", s)
+}
+
+type connLimits struct {
+	maxConns      int // 总连接数上限，0 则不限制
+	maxConnsPerIP int // 单个 remote ip 的连接数上限，0 则不限制
+	policy        LimitPolicy
+}
+
+// 连接计数
+type ServerStats struct {
+	Active        int    // 当前连接数
+	Accepted      uint64 // 累计接受的连接数
+	Rejected      uint64 // 因总数或 listener 上限被拒绝的连接数
+	RejectedPerIP uint64 // 因单 IP 上限被拒绝的连接数
+
+	RateDelayed      uint64 // 因限速被延迟读取的请求数
+	RateOverloaded   uint64 // 因限速被丢弃并回复 overload 的请求数
+	RateDisconnected uint64 // 因限速被断开的连接数
+}
+
+func WithServerMaxConns(n int) ServerOption {
+	return func(s *Server) {
+		s.limits.maxConns = n
+	}
+}
+
+func WithServerMaxConnsPerIP(n int) ServerOption {
+	return func(s *Server) {
+		s.limits.maxConnsPerIP = n
+	}
+}
+
+func WithServerLimitPolicy(p LimitPolicy) ServerOption {
+	return func(s *Server) {
+		s.limits.policy = p
+	}
+}
+
+func (l connLimits) validate(errs *ConfigError) {
+	if l.maxConns < 0 {
+		errs.Addf("server maxConns must not be negative, got %d", l.maxConns)
+	}
+	if l.maxConnsPerIP < 0 {
+		errs.Addf("server maxConnsPerIP must not be negative, got %d", l.maxConnsPerIP)
+	}
+	if l.policy < LIMIT_REJECT || l.policy > LIMIT_QUEUE {
+		errs.Addf("unknown server limit policy %d", int(l.policy))
+	}
+}
+
+func (s *Server) Stats() ServerStats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	stats := s.stats
+	stats.Active = len(s.workers)
+	stats.RateDelayed = atomic.LoadUint64(&s.rateStats.delayed)
+	stats.RateOverloaded = atomic.LoadUint64(&s.rateStats.overloaded)
+	stats.RateDisconnected = atomic.LoadUint64(&s.rateStats.disconnected)
+	return stats
+}
+
+// 总数或 listener 的连接数已满，需持有 s.lock
+func (s *Server) full(l *LiveListener) bool {
+	if s.limits.maxConns > 0 && len(s.workers) >= s.limits.maxConns {
+		return true
+	}
+	return l.conf != nil && l.conf.MaxConns > 0 && l.conns >= l.conf.MaxConns
+}
+
+// 排队策略下等待空闲名额再 accept
+func (s *Server) waitSlot(l *LiveListener) {
+	s.lock.Lock()
+	for !s.closed && s.limits.policy == LIMIT_QUEUE && s.full(l) {
+		s.slotCond.Wait()
+	}
+	s.lock.Unlock()
+}
+
+// 检查并占用连接名额，需持有 s.lock
+func (s *Server) admit(conn net.Conn, l *LiveListener) bool {
+	if s.full(l) {
+		s.stats.Rejected++
+		return false
+	}
+	ip := remoteIP(conn)
+	if ip != "" && s.limits.maxConnsPerIP > 0 && s.perIP[ip] >= s.limits.maxConnsPerIP {
+		s.stats.RejectedPerIP++
+		return false
+	}
+
+	if ip != "" {
+		s.perIP[ip]++
+	}
+	l.conns++
+	s.stats.Accepted++
+	return true
+}
+
+// 归还连接名额，需持有 s.lock
+func (s *Server) release(conn net.Conn, l *LiveListener) {
+	if ip := remoteIP(conn); ip != "" {
+		if s.perIP[ip]--; s.perIP[ip] <= 0 {
+			delete(s.perIP, ip)
+			delete(s.ipBuckets, ip)
+		}
+	}
+	l.conns--
+	s.slotCond.Broadcast()
+}
+
+// 同一 ip 的连接共享令牌桶，需持有 s.lock
+func (s *Server) ipBucket(conn net.Conn) *TokenBucket {
+	ip := remoteIP(conn)
+	if ip == "" {
+		return nil
+	}
+	b, ok := s.ipBuckets[ip]
+	if !ok {
+		b = NewTokenBucket(s.loadRates().ip)
+		s.ipBuckets[ip] = b
+	}
+	return b
+}
+
+func (s *Server) reject(conn net.Conn, codec Codec) {
+	if s.limits.policy != LIMIT_BUSY {
+		conn.Close()
+		return
+	}
+
+	// 不阻塞 accept 循环
+	go func() {
+		defer conn.Close()
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		conn.Write(codec.MarshalPacket(*NewBusyPacket()))
+	}()
+}
+
+// 非 IP 地址（如 unix socket、内存管道）返回空，不参与单 IP 限制
+func remoteIP(conn net.Conn) string {
+	switch addr := conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case *net.UDPAddr:
+		return addr.IP.String()
+	}
+	return ""
+}
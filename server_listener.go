@@ -1,9 +1,13 @@
 package tron
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"github.com/wuYin/logx"
 	"net"
+	"os"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,25 +15,48 @@ var ERR_SERVER_CLOSED = errors.New("server closed")
 
 // 手动维护的长连接连接器
 type LiveListener struct {
-	listener  *net.TCPListener
+	listener  net.Listener
 	closeCh   chan struct{} // 异步主动关闭连接
-	keepAlive time.Duration // 保活时间
+	keepAlive int64         // 保活时间，可热更新
+	key       string        // 平滑重启时交接给子进程的地址标识
+	conf      *ListenerConfig
+	conns     int // 该 listener 上的连接数，由 server 加锁维护
 }
 
-func NewLiveListener(l *net.TCPListener, ch chan struct{}, d time.Duration) *LiveListener {
+// 额外监听地址的配置，未指定的项沿用 server 的配置
+type ListenerConfig struct {
+	Network  string      // tcp4、tcp、unix 等，默认 tcp4
+	Addr     string      // 监听地址，unix 为 socket 路径
+	Codec    Codec       // 该地址上连接使用的 codec
+	TLS      *tls.Config // 非 nil 时对接受的连接做 TLS 握手
+	Config   *Config     // 该地址上连接的 session 配置
+	MaxConns int         // 该地址上的最大连接数，0 则不限制
+}
+
+func (lc *ListenerConfig) network() string {
+	if lc.Network == "" {
+		return "tcp4"
+	}
+	return lc.Network
+}
+
+func NewLiveListener(l net.Listener, ch chan struct{}, d time.Duration) *LiveListener {
 	listener := &LiveListener{
 		listener:  l,
 		closeCh:   ch,
-		keepAlive: d,
+		keepAlive: int64(d),
 	}
 	return listener
 }
 
-func (l *LiveListener) AcceptTCP() (*net.TCPConn, error) {
+func (l *LiveListener) Accept() (net.Conn, error) {
 	for {
-		conn, err := l.listener.AcceptTCP()
+		conn, err := l.listener.Accept()
 		select {
 		case <-l.closeCh:
+			if conn != nil { // 关闭期间接受的连接不再处理，直接断开
+				conn.Close()
+			}
 			if err := l.listener.Close(); err != nil {
 				logx.Error(err)
 			}
@@ -40,8 +67,38 @@ func (l *LiveListener) AcceptTCP() (*net.TCPConn, error) {
 		if err != nil {
 			return nil, err
 		}
-		conn.SetKeepAlive(true)
-		conn.SetKeepAlivePeriod(l.keepAlive)
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetKeepAlive(true)
+			tcpConn.SetKeepAlivePeriod(time.Duration(atomic.LoadInt64(&l.keepAlive)))
+		}
 		return conn, nil
 	}
 }
+
+// 修改新连接的保活时间
+func (l *LiveListener) SetKeepAlive(d time.Duration) {
+	atomic.StoreInt64(&l.keepAlive, int64(d))
+}
+
+func (l *LiveListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// 直接关闭底层 listener，阻塞中的 Accept 立即返回
+func (l *LiveListener) Close() error {
+	return l.listener.Close()
+}
+
+// 复制底层 socket 的文件描述符，用于交给子进程继承
+func (l *LiveListener) File() (*os.File, error) {
+	fl, ok := l.listener.(interface {
+		File() (*os.File, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("listener %s does not support fd handoff", l.Addr())
+	}
+	if ul, ok := l.listener.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false) // 子进程仍在使用该 socket 文件
+	}
+	return fl.File()
+}
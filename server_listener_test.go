@@ -0,0 +1,32 @@
+package tron
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// server 关闭后 Accept 到的连接被关闭，对端不会一直挂起
+func TestLiveListenerClosesConnAfterShutdown(t *testing.T) {
+	closeCh := make(chan struct{})
+	l := NewLiveListener(NewLoopbackListener("live"), closeCh, time.Second)
+	close(closeCh)
+
+	dialed := make(chan error, 1)
+	go func() {
+		conn, err := l.listener.(*LoopbackListener).Dial()
+		if err != nil {
+			dialed <- err
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		dialed <- err
+	}()
+	if _, err := l.Accept(); err != ERR_SERVER_CLOSED {
+		t.Fatalf("want ERR_SERVER_CLOSED, got %v", err)
+	}
+	if err := <-dialed; err != io.EOF {
+		t.Fatalf("peer read: want io.EOF, got %v", err)
+	}
+}
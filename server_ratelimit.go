@@ -0,0 +1,242 @@
+package tron
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 请求超过限速时的处理方式
+type RateAction int
+
+const (
+	RATE_DELAY      RateAction = iota // 暂停读取直到有令牌，由 TCP 反压到 client
+	RATE_OVERLOAD                     // 丢弃请求并回复 overload 控制包
+	RATE_DISCONNECT                   // 断开连接
+)
+
+func (a RateAction) String() string {
+	switch a {
+	case RATE_DELAY:
+		return "delay"
+	case RATE_OVERLOAD:
+		return "overload"
+	case RATE_DISCONNECT:
+		return "disconnect"
+	}
+	return fmt.Sprintf("RateAction(%d)", int(a))
+}
+
+func ParseRateAction(s string) (RateAction, error) {
+	for _, a := range []RateAction{RATE_DELAY, RATE_OVERLOAD, RATE_DISCONNECT} {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown rate action %q", s)
+}
+
+// 限速配置，修改时整体替换
+type rateLimits struct {
+	session  RateLimit            // 单个连接
+	ip       RateLimit            // 同一 remote ip 的所有连接
+	commands map[string]RateLimit // 单个连接上的各类命令
+	command  func(p *Packet) string
+	action   RateAction
+}
+
+func (r *rateLimits) clone() *rateLimits {
+	c := *r
+	c.commands = make(map[string]RateLimit, len(r.commands))
+	for cmd, l := range r.commands {
+		c.commands[cmd] = l
+	}
+	return &c
+}
+
+func (r *rateLimits) validate(errs *ConfigError) {
+	check := func(name string, l RateLimit) {
+		if l.Rate < 0 || l.Burst < 0 {
+			errs.Addf("%s rate limit must not be negative, got %+v", name, l)
+		}
+	}
+	check("session", r.session)
+	check("ip", r.ip)
+	for cmd, l := range r.commands {
+		check(fmt.Sprintf("command %q", cmd), l)
+	}
+	if len(r.commands) > 0 && r.command == nil {
+		errs.Addf("command rate limits require a command func")
+	}
+	if r.action < RATE_DELAY || r.action > RATE_DISCONNECT {
+		errs.Addf("unknown rate action %d", int(r.action))
+	}
+}
+
+// 是否配置了任一限速
+func (r *rateLimits) limited() bool {
+	if r.session.Enabled() || r.ip.Enabled() {
+		return true
+	}
+	for _, l := range r.commands {
+		if l.Enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// 除 command func 外的各项配置相同时无需热更新，func 是否替换由 Reload 判断
+func (r *rateLimits) equal(o *rateLimits) bool {
+	if r.session != o.session || r.ip != o.ip || r.action != o.action || len(r.commands) != len(o.commands) {
+		return false
+	}
+	for cmd, l := range r.commands {
+		if ol, ok := o.commands[cmd]; !ok || ol != l {
+			return false
+		}
+	}
+	return true
+}
+
+// 限速计数
+type rateStats struct {
+	delayed      uint64
+	overloaded   uint64
+	disconnected uint64
+}
+
+func (s *Server) loadRates() *rateLimits {
+	return s.rates.Load().(*rateLimits)
+}
+
+func (s *Server) updateRates(f func(r *rateLimits)) {
+	r := s.loadRates().clone()
+	f(r)
+	s.rates.Store(r)
+}
+
+func WithServerSessionRate(l RateLimit) ServerOption {
+	return func(s *Server) {
+		s.updateRates(func(r *rateLimits) { r.session = l })
+	}
+}
+
+func WithServerIPRate(l RateLimit) ServerOption {
+	return func(s *Server) {
+		s.updateRates(func(r *rateLimits) { r.ip = l })
+	}
+}
+
+// 限制单个连接上某类命令的速率，命令类型由 WithServerCommandFunc 解析
+func WithServerCommandRate(cmd string, l RateLimit) ServerOption {
+	return func(s *Server) {
+		s.updateRates(func(r *rateLimits) { r.commands[cmd] = l })
+	}
+}
+
+// 从请求包中解析命令类型
+func WithServerCommandFunc(f func(p *Packet) string) ServerOption {
+	return func(s *Server) {
+		s.updateRates(func(r *rateLimits) { r.command = f })
+		s.funcOpts |= OPT_COMMAND_FUNC
+	}
+}
+
+func WithServerRateAction(a RateAction) ServerOption {
+	return func(s *Server) {
+		s.updateRates(func(r *rateLimits) { r.action = a })
+	}
+}
+
+// 单个连接的限速状态
+type packetLimiter struct {
+	server   *Server
+	worker   *Client
+	session  *TokenBucket
+	ip       *TokenBucket // 同 ip 连接共享
+	lock     sync.Mutex
+	commands map[string]*TokenBucket
+}
+
+func (s *Server) newPacketLimiter(worker *Client, ip *TokenBucket) *packetLimiter {
+	return &packetLimiter{
+		server:   s,
+		worker:   worker,
+		session:  NewTokenBucket(s.loadRates().session),
+		ip:       ip,
+		commands: make(map[string]*TokenBucket),
+	}
+}
+
+func (l *packetLimiter) commandBucket(cmd string, limit RateLimit) *TokenBucket {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	b, ok := l.commands[cmd]
+	if !ok {
+		b = NewTokenBucket(limit)
+		l.commands[cmd] = b
+	}
+	return b
+}
+
+// 在读协程中检查读到的包，返回 false 表示该包已被丢弃
+// 流的 OPEN、FIN 与窗口包不限速，丢弃它们会使流永久挂起
+func (l *packetLimiter) allow(p *Packet) bool {
+	if p.Header.Flags&(FLAG_WINDOW|FLAG_STREAM_OPEN|FLAG_STREAM_FIN) != 0 {
+		return true
+	}
+	rates := l.server.loadRates()
+	buckets := []*TokenBucket{l.session}
+	limits := []RateLimit{rates.session}
+	if l.ip != nil {
+		buckets = append(buckets, l.ip)
+		limits = append(limits, rates.ip)
+	}
+	if rates.command != nil {
+		cmd := rates.command(p)
+		if limit, ok := rates.commands[cmd]; ok {
+			buckets = append(buckets, l.commandBucket(cmd, limit))
+			limits = append(limits, limit)
+		}
+	}
+
+	if rates.action == RATE_DELAY {
+		var wait time.Duration
+		for i, b := range buckets {
+			if d := b.Reserve(limits[i]); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			atomic.AddUint64(&l.server.rateStats.delayed, 1)
+			time.Sleep(wait) // 暂停读取
+		}
+		return true
+	}
+
+	if allowAll(buckets, limits) {
+		return true
+	}
+	if rates.action == RATE_DISCONNECT {
+		atomic.AddUint64(&l.server.rateStats.disconnected, 1)
+		l.worker.session.Close()
+		return false
+	}
+	atomic.AddUint64(&l.server.rateStats.overloaded, 1)
+	if p.Header.Flags&FLAG_STREAM != 0 { // 流的 seq 不对应等待中的请求，只归还窗口
+		l.worker.discardStream(p)
+	} else if p.Header.Seq >= 0 {
+		l.worker.session.Write(NewOverloadPacket(p.Header.Seq))
+	}
+	return false
+}
+
+// 请求被限速丢弃时回复的控制包，data 为被丢弃请求的 seq
+func NewOverloadPacket(seq int32) *Packet {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(seq))
+	return NewRespPacket(SEQ_OVERLOAD, data)
+}
@@ -0,0 +1,218 @@
+package tron
+
+import (
+	"logx"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+)
+
+// 热更新结果，按生效范围列出发生变化的配置项
+type ReloadReport struct {
+	Applied         []string // 已对新连接与存量连接生效
+	NewConnsOnly    []string // 仅对之后建立的连接生效
+	RestartRequired []string // 需重启进程才能生效，本次未应用
+}
+
+func (r *ReloadReport) Changed() bool {
+	return len(r.Applied)+len(r.NewConnsOnly)+len(r.RestartRequired) > 0
+}
+
+// 运行时应用新配置，不断开已有连接
+// 未指定的配置保持不变，校验失败时不做任何修改
+func (s *Server) Reload(opts ...ServerOption) (*ReloadReport, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	next := &Server{
+		address:    s.address,
+		handler:    s.handler,
+		streams:    s.streams,
+		conf:       s.conf,
+		keepAlive:  s.keepAlive,
+		codec:      s.codec,
+		reusePort:  s.reusePort,
+		eventLoops: s.eventLoops,
+		extra:      append([]ListenerConfig(nil), s.extra...),
+		limits:     s.limits,
+	}
+	next.rates.Store(s.loadRates())
+	for _, opt := range opts {
+		opt(next)
+	}
+	if err := next.validate(); err != nil {
+		return nil, err
+	}
+
+	report := &ReloadReport{}
+	if next.address != s.address {
+		report.RestartRequired = append(report.RestartRequired, "address")
+	}
+	if next.reusePort != s.reusePort {
+		report.RestartRequired = append(report.RestartRequired, "reusePort")
+	}
+	if next.eventLoops != s.eventLoops {
+		report.RestartRequired = append(report.RestartRequired, "eventLoops")
+	}
+	if !sameListeners(next.extra, s.extra) {
+		report.RestartRequired = append(report.RestartRequired, "listeners")
+	}
+	if next.limits != s.limits {
+		s.limits = next.limits
+		s.slotCond.Broadcast() // 上限调大后唤醒排队的 accept
+		report.Applied = append(report.Applied, "connLimits")
+	}
+	if rates := next.loadRates(); !rates.equal(s.loadRates()) || next.funcOpts&OPT_COMMAND_FUNC != 0 {
+		s.rates.Store(rates) // 读协程下次检查即按新配置限速
+		report.Applied = append(report.Applied, "rateLimits")
+	}
+	if next.keepAlive != s.keepAlive {
+		s.keepAlive = next.keepAlive
+		for _, l := range s.listeners {
+			l.SetKeepAlive(next.keepAlive)
+		}
+		report.NewConnsOnly = append(report.NewConnsOnly, "keepAlive")
+	}
+	if !sameCodec(next.codec, s.codec) {
+		s.codec = next.codec
+		report.NewConnsOnly = append(report.NewConnsOnly, "codec")
+	}
+	if next.funcOpts&OPT_HANDLER != 0 {
+		s.handler = next.handler
+		report.NewConnsOnly = append(report.NewConnsOnly, "handler")
+	}
+	if next.funcOpts&OPT_STREAM_HANDLER != 0 {
+		s.streams = next.streams
+		report.NewConnsOnly = append(report.NewConnsOnly, "streamHandler")
+	}
+	if next.conf != s.conf {
+		s.conf = s.reloadConfig(next.conf, report)
+	}
+	return report, nil
+}
+
+// 合并 session 配置，已分配的 seq 仍在使用，SeqManager 不可替换
+func (s *Server) reloadConfig(conf *Config, report *ReloadReport) *Config {
+	old := s.conf
+	merged := *conf
+	merged.MaxSeq = old.MaxSeq
+	merged.SeqManager = old.SeqManager
+
+	if conf.MaxSeq != old.MaxSeq {
+		report.RestartRequired = append(report.RestartRequired, "MaxSeq")
+	}
+	if conf.ReadBufSize != old.ReadBufSize {
+		report.NewConnsOnly = append(report.NewConnsOnly, "ReadBufSize")
+	}
+	if conf.WriteBufSize != old.WriteBufSize {
+		report.NewConnsOnly = append(report.NewConnsOnly, "WriteBufSize")
+	}
+	if conf.ReadChanSize != old.ReadChanSize {
+		report.NewConnsOnly = append(report.NewConnsOnly, "ReadChanSize")
+	}
+	if conf.WriteChanSize != old.WriteChanSize {
+		report.NewConnsOnly = append(report.NewConnsOnly, "WriteChanSize")
+	}
+	if conf.WriteBatch != old.WriteBatch {
+		report.NewConnsOnly = append(report.NewConnsOnly, "WriteBatch")
+	}
+	if conf.WriteDelay != old.WriteDelay {
+		report.NewConnsOnly = append(report.NewConnsOnly, "WriteDelay")
+	}
+	if conf.Compression != old.Compression {
+		report.NewConnsOnly = append(report.NewConnsOnly, "Compression")
+	}
+	if conf.CompressMin != old.CompressMin {
+		report.NewConnsOnly = append(report.NewConnsOnly, "CompressMin")
+	}
+	if conf.Checksum != old.Checksum {
+		report.NewConnsOnly = append(report.NewConnsOnly, "Checksum")
+	}
+	if conf.ChecksumPolicy != old.ChecksumPolicy {
+		report.NewConnsOnly = append(report.NewConnsOnly, "ChecksumPolicy")
+	}
+	if conf.FragmentSize != old.FragmentSize {
+		report.NewConnsOnly = append(report.NewConnsOnly, "FragmentSize")
+	}
+	if conf.PartialBytes != old.PartialBytes {
+		report.NewConnsOnly = append(report.NewConnsOnly, "PartialBytes")
+	}
+	if conf.Batch != old.Batch {
+		report.NewConnsOnly = append(report.NewConnsOnly, "Batch")
+	}
+	if conf.StreamWindow != old.StreamWindow {
+		report.NewConnsOnly = append(report.NewConnsOnly, "StreamWindow")
+	}
+	if conf.ConnWindow != old.ConnWindow {
+		report.NewConnsOnly = append(report.NewConnsOnly, "ConnWindow")
+	}
+	if conf.IdleDuration != old.IdleDuration {
+		for worker, l := range s.workers {
+			if l.conf == nil || l.conf.Config == nil { // 使用独立配置的 listener 不受影响
+				worker.session.SetIdleDuration(conf.IdleDuration)
+			}
+		}
+		report.Applied = append(report.Applied, "IdleDuration")
+	}
+	return &merged
+}
+
+// 收到 SIGHUP 时调用 load 获取新配置并热更新，返回取消监听的函数
+func (s *Server) ReloadOnSignal(load func() ([]ServerOption, error)) (stop func()) {
+	sigCh := make(chan os.Signal, 1)
+	doneCh := make(chan struct{})
+	signal.Notify(sigCh, syscall.SIGHUP)
+
+	go func() {
+		for {
+			select {
+			case <-sigCh:
+				opts, err := load()
+				if err != nil {
+					logx.Error("reload: load config failed: %v", err)
+					continue
+				}
+				report, err := s.Reload(opts...)
+				if err != nil {
+					logx.Error("reload: %v", err)
+					continue
+				}
+				logx.Debug("reload: applied %v, new connections only %v, restart required %v",
+					report.Applied, report.NewConnsOnly, report.RestartRequired)
+			case <-doneCh:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(doneCh)
+	}
+}
+
+// 额外监听地址及其配置均需重启生效
+func sameListeners(a, b []ListenerConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Network != b[i].Network || a[i].Addr != b[i].Addr || a[i].MaxConns != b[i].MaxConns ||
+			a[i].TLS != b[i].TLS || a[i].Config != b[i].Config || !sameCodec(a[i].Codec, b[i].Codec) {
+			return false
+		}
+	}
+	return true
+}
+
+// 直接以 == 比较不可比较的 Codec 值会 panic，此类值视为已变化
+func sameCodec(a, b Codec) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if t := reflect.TypeOf(a); t != reflect.TypeOf(b) || !t.Comparable() {
+		return false
+	}
+	return a == b
+}
@@ -0,0 +1,134 @@
+package tron
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 子进程继承的 listener，格式为 addr=fd，多个以逗号分隔
+const ENV_LISTEN_FDS = "TRON_LISTEN_FDS"
+
+var (
+	inheritOnce sync.Once
+	inheritErr  error
+	inherited   map[string]*os.File // addr -> 继承的 fd
+	inheritLock sync.Mutex
+)
+
+// 取出父进程交接的 addr 对应 listener，未继承时返回 nil
+// 每个 fd 只会被取用一次
+func inheritedListener(addr string) (net.Listener, error) {
+	inheritOnce.Do(parseInheritedFds)
+	if inheritErr != nil {
+		return nil, inheritErr
+	}
+
+	inheritLock.Lock()
+	f, ok := inherited[addr]
+	delete(inherited, addr)
+	inheritLock.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	l, err := net.FileListener(f) // 复制了 fd，原文件可关闭
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("inherit listener %s: %v", addr, err)
+	}
+	return l, nil
+}
+
+func parseInheritedFds() {
+	inherited = make(map[string]*os.File)
+	v := os.Getenv(ENV_LISTEN_FDS)
+	if v == "" {
+		return
+	}
+	os.Unsetenv(ENV_LISTEN_FDS) // 避免再传给孙进程
+
+	for _, item := range strings.Split(v, ",") {
+		i := strings.LastIndex(item, "=")
+		if i <= 0 {
+			inheritErr = fmt.Errorf("invalid %s item %q", ENV_LISTEN_FDS, item)
+			return
+		}
+		fd, err := strconv.Atoi(item[i+1:])
+		if err != nil || fd < 3 {
+			inheritErr = fmt.Errorf("invalid %s fd %q", ENV_LISTEN_FDS, item)
+			return
+		}
+		inherited[item[:i]] = os.NewFile(uintptr(fd), "tron-listener-"+item[:i])
+	}
+}
+
+// 以相同参数启动新的二进制，并将所有 listener 的 fd 交给子进程
+// 返回后新旧进程同时接受连接，旧进程应随后调用 Drain
+func (s *Server) Upgrade() (*os.Process, error) {
+	bin, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.RLock()
+	listeners := make([]*LiveListener, len(s.listeners))
+	copy(listeners, s.listeners)
+	s.lock.RUnlock()
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close() // 子进程已持有副本
+		}
+	}()
+
+	var fds []string
+	for _, l := range listeners {
+		f, err := l.File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		fds = append(fds, fmt.Sprintf("%s=%d", l.key, 3+len(files)-1)) // ExtraFiles 从 fd 3 开始
+	}
+
+	cmd := exec.Command(bin, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), ENV_LISTEN_FDS+"="+strings.Join(fds, ","))
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
+
+// 停止接受新连接并等待存量会话自然结束，超时后强制关闭剩余会话
+// 返回被强制关闭的会话数
+func (s *Server) Drain(timeout time.Duration) int {
+	s.Shutdown()
+
+	deadline := time.Now().Add(timeout)
+	for s.NumSessions() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	s.lock.RLock()
+	var remain []*Client
+	for worker := range s.workers {
+		remain = append(remain, worker)
+	}
+	s.lock.RUnlock()
+
+	for _, worker := range remain {
+		worker.session.Close()
+	}
+	return len(remain)
+}
@@ -0,0 +1,107 @@
+//go:build linux
+// +build linux
+
+package tron
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 重新读取 ENV_LISTEN_FDS，模拟子进程启动
+func resetInherited() {
+	inheritOnce = sync.Once{}
+	inheritErr = nil
+	inherited = nil
+}
+
+func replyServer(t *testing.T, reply string) *Server {
+	s, err := NewServerWith("127.0.0.1:0", WithServerHandler(func(worker *Client, p *Packet) {
+		worker.AsyncWrite(NewRespPacket(p.Header.Seq, []byte(reply)))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ListenAndServe(); err != nil {
+		t.Fatal(err)
+	}
+	<-s.Ready()
+	return s
+}
+
+// 同一进程内按 TRON_LISTEN_FDS 重建 server，新 server 接管原端口
+func TestInheritedListener(t *testing.T) {
+	defer resetInherited()
+	parent := replyServer(t, "parent")
+	defer parent.Shutdown()
+
+	// 子进程中 fd 由 ExtraFiles 传入，这里复制一份模拟
+	f, err := parent.listeners[0].File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(ENV_LISTEN_FDS, fmt.Sprintf("%s=%d", parent.listeners[0].key, fd))
+	defer os.Unsetenv(ENV_LISTEN_FDS)
+	resetInherited()
+
+	child := replyServer(t, "child")
+	defer child.Shutdown()
+	if child.Addr().String() != parent.Addr().String() {
+		t.Fatalf("child listens on %s, want inherited %s", child.Addr(), parent.Addr())
+	}
+	if v := os.Getenv(ENV_LISTEN_FDS); v != "" {
+		t.Fatalf("%s not cleared: %q", ENV_LISTEN_FDS, v)
+	}
+
+	if n := parent.Drain(time.Second); n != 0 {
+		t.Fatalf("parent drain closed %d sessions", n)
+	}
+	cli, err := Dial(context.Background(), parent.Addr().String(), WithClientHandler(func(cli *Client, p *Packet) {
+		cli.NotifyReceived(p.Header.Seq, p.Data)
+	}))
+	if err != nil {
+		t.Fatalf("dial after parent drained: %v", err)
+	}
+	defer cli.Close()
+	resp, err := cli.SyncWrite(NewReqPacket([]byte("ping")), time.Second)
+	if err != nil || string(resp.([]byte)) != "child" {
+		t.Fatalf("want reply from child, got %v %v", resp, err)
+	}
+}
+
+func TestInheritedListenerInvalidEnv(t *testing.T) {
+	defer resetInherited()
+	cases := []struct {
+		env  string
+		want string
+	}{
+		{"127.0.0.1:0", "invalid " + ENV_LISTEN_FDS + " item"},
+		{"127.0.0.1:0=x", "invalid " + ENV_LISTEN_FDS + " fd"},
+		{"127.0.0.1:0=1", "invalid " + ENV_LISTEN_FDS + " fd"},
+	}
+	for _, c := range cases {
+		os.Setenv(ENV_LISTEN_FDS, c.env)
+		resetInherited()
+		s, err := NewServerWith("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.ListenAndServe()
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			s.Shutdown()
+			t.Fatalf("%s: error %v does not mention %q", c.env, err, c.want)
+		}
+	}
+	os.Unsetenv(ENV_LISTEN_FDS)
+}
@@ -5,133 +5,473 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"io"
 	"logx"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // 某个连接的会话信息
 type Session struct {
-	conn      *net.TCPConn
-	cr        *bufio.Reader // 连接缓冲 reader
-	cw        *bufio.Writer // 连接缓冲 writer
-	ReadCh    chan *Packet  // 读请求的 channel
-	WriteCh   chan *Packet  // 写响应的 channel
-	closed    bool
-	idleTimer *time.Timer
-	conf      *Config
-	codec     Codec
-}
-
-func NewSession(conn *net.TCPConn, conf *Config, codec Codec) *Session {
-	conn.SetReadBuffer(conf.ReadBufSize)
-	conn.SetWriteBuffer(conf.WriteBufSize)
+	conn       net.Conn
+	cr         *bufio.Reader // 连接缓冲 reader
+	cw         *bufio.Writer // 连接缓冲 writer
+	ReadCh     chan *Packet  // 读请求的 channel
+	WriteCh    chan *Packet  // 写响应的 channel，即 normal 优先级的队列
+	closed     bool
+	lock       sync.RWMutex // 保护 closed 与 WriteCh 的关闭
+	idle       int64        // 最大空闲时间，可热更新
+	idleTimer  *time.Timer  // 空闲检查，仅 server 端会话启用
+	lastRead   int64        // 最后读到包的时间（UnixNano）
+	conf       *Config
+	codec      Codec
+	onClose    func()               // 会话关闭后的回调
+	limit      func(p *Packet) bool // 读到包后的限速检查，返回 false 则丢弃
+	vectored   bool                 // 连接支持 writev，绕过 cw 直接写
+	hdrs       []byte               // writev 复用的包头缓冲
+	vec        net.Buffers          // writev 复用的分段
+	direct     bool                 // 事件循环模式，无读协程与缓冲，Write 进入 outq
+	detach     func()               // 关闭连接前从事件循环中摘除
+	outq       []*Packet            // 事件循环模式的写队列，由 flushDirect 协程发送
+	outLock    sync.Mutex
+	outCond    *sync.Cond    // outq 有空位或会话关闭
+	flushing   bool          // flushDirect 协程运行中
+	offered    int32         // 由本端发起协商
+	accepted   chan struct{} // 收到协商回复后关闭
+	acceptOnce sync.Once
+	done       chan struct{} // 关闭时首先关闭，唤醒阻塞的写
+	doneOnce   sync.Once
+	peerFlags  uint32                       // 对端可接收的 flags
+	crcErrors  uint64                       // 校验失败的包数
+	peerCRC    int32                        // 对端已确认此后的包均附带校验和
+	frags      []*fragment                  // 分片发送中的大包，仅写协程访问
+	frames     []*Packet                    // 复用的待写包
+	fragID     uint32                       // 分片消息 id
+	partial    map[uint32][]byte            // 重组中的消息，仅读协程访问
+	partialLen int                          // partial 中的总字节数
+	sendFlow   *flow                        // 连接上流的发送窗口
+	recvCredit credit                       // 连接上为对端开放的窗口与已接收待归还的字节数
+	credits    map[int32]int64              // 队列已满时待发送的窗口增量，按 seq 合并
+	creditLock sync.Mutex                   // 保护 credits
+	lanes      [PRIORITY_LANES]chan *Packet // 各优先级的写队列
+}
+
+func NewSession(conn net.Conn, conf *Config, codec Codec) *Session {
+	vectored := false
+	switch c := conn.(type) { // 内存管道等连接无内核缓冲区
+	case *net.TCPConn:
+		c.SetReadBuffer(conf.ReadBufSize)
+		c.SetWriteBuffer(conf.WriteBufSize)
+		vectored = true
+	case *net.UnixConn:
+		vectored = true
+	}
+	s := &Session{
+		conn:     conn,
+		cr:       bufio.NewReaderSize(conn, conf.ReadBufSize),
+		cw:       bufio.NewWriterSize(conn, conf.WriteBufSize),
+		ReadCh:   make(chan *Packet, conf.ReadChanSize),
+		WriteCh:  make(chan *Packet, conf.WriteChanSize),
+		closed:   false,
+		idle:     int64(conf.IdleDuration),
+		conf:     conf,
+		codec:    codec,
+		vectored: vectored,
+		accepted: make(chan struct{}),
+		done:     make(chan struct{}),
+		sendFlow: newFlow(INITIAL_WINDOW),
+	}
+	for prio := range s.lanes {
+		s.lanes[prio] = make(chan *Packet, conf.WriteChanSize)
+	}
+	s.lanes[PRIORITY_NORMAL] = s.WriteCh
+	return s
+}
+
+// 事件循环模式的会话，读由 poller 完成，写由有待发数据时才启动的协程进行
+// 不分配读写缓冲与 channel，空闲连接只占用少量内存且没有协程
+func newDirectSession(conn net.Conn, conf *Config, codec Codec) *Session {
 	s := &Session{
-		conn:      conn,
-		cr:        bufio.NewReaderSize(conn, conf.ReadBufSize),
-		cw:        bufio.NewWriterSize(conn, conf.WriteBufSize),
-		ReadCh:    make(chan *Packet, conf.ReadChanSize),
-		WriteCh:   make(chan *Packet, conf.WriteChanSize),
-		closed:    false,
-		idleTimer: time.NewTimer(conf.IdleDuration),
-		conf:      conf,
-		codec:     codec,
+		conn:     conn,
+		idle:     int64(conf.IdleDuration),
+		conf:     conf,
+		codec:    codec,
+		direct:   true,
+		accepted: make(chan struct{}),
+		done:     make(chan struct{}),
+		sendFlow: newFlow(INITIAL_WINDOW),
 	}
+	s.outCond = sync.NewCond(&s.outLock)
 	return s
 }
 
 // 读取数据
+// ReadCh 只由读协程写入，退出时由其关闭
 func (s *Session) daemonReadPacket() {
+	defer close(s.ReadCh)
 	buf := bytes.NewBuffer(nil)
-	for !s.closed {
+	for !s.IsClosed() {
 		b, err := s.codec.ReadPacket(s.cr)
 		if err != nil {
+			if IsProtocolError(err) { // 对端数据不合法，仅关闭当前会话
+				logx.Error("%s -> %s %v", s.LocalAddr(), s.RemoteAddr(), err)
+			}
 			fmt.Printf("%s -> %s session closed.\n", s.LocalAddr(), s.RemoteAddr())
-			s.closed = true
+			s.Close()
 			return
 		}
 		// fmt.Printf("%s -> %s read: %v\n", s.LocalAddr(), s.RemoteAddr(), string(b))
 
 		p, err := s.codec.UnmarshalPacket(b)
+		if err == nil {
+			p, err = s.inbound(p)
+		}
+		if err != nil && s.dropCorrupted(err) {
+			continue
+		}
 		if err != nil {
 			fmt.Printf("session: unmarshal packet failed: %v\n", err)
-			s.closed = true
+			s.Close()
 			return
 		}
-
-		// 写入读缓冲
-		s.ReadCh <- p
-		s.idleTimer.Reset(s.conf.IdleDuration) // 重设空闲 timer
+		if p == nil { // 协商包等已在 session 内处理
+			continue
+		}
+		if p.Header.Flags&FLAG_BATCH != 0 { // 批量帧拆开后逐个限速
+			items, err := unbatch(p)
+			if err != nil {
+				logx.Error("%s -> %s %v", s.LocalAddr(), s.RemoteAddr(), err)
+				s.Close()
+				return
+			}
+			for _, item := range items {
+				s.deliver(item)
+			}
+			continue
+		}
+		s.deliver(p)
 		buf.Reset()
 	}
 }
 
+// 限速后写入读缓冲
+func (s *Session) deliver(p *Packet) {
+	if s.limit != nil && !s.limit(p) {
+		p.Release() // 被限速丢弃
+		return
+	}
+	s.ReadCh <- p
+	s.touch()
+}
+
+// 记录读到包的时间，供空闲检查使用
+func (s *Session) touch() {
+	atomic.StoreInt64(&s.lastRead, time.Now().UnixNano())
+}
+
+// 启用空闲检查，超过 IdleDuration 未读到包则关闭会话
+// 需在读写开始前调用
+func (s *Session) watchIdle() {
+	s.touch()
+	s.idleTimer = time.AfterFunc(s.IdleDuration(), s.checkIdle)
+}
+
+func (s *Session) checkIdle() {
+	if s.IsClosed() {
+		return
+	}
+	idle := time.Since(time.Unix(0, atomic.LoadInt64(&s.lastRead)))
+	if left := s.IdleDuration() - idle; left > 0 {
+		s.idleTimer.Reset(left)
+		return
+	}
+	logx.Error("%s -> %s idle for %v, closing", s.LocalAddr(), s.RemoteAddr(), idle)
+	s.Close()
+}
+
 // 写入响应
+// 每次按优先级权重取出当前排队的包（至多 WriteBatch 个），合并后只 flush 一次
 func (s *Session) daemonWritePacket() {
-	for !s.closed {
-		if p, ok := <-s.WriteCh; ok {
-			// write to buffer
-			buf := s.codec.MarshalPacket(*p)
-			if buf == nil || len(buf) == 0 {
-				logx.Error("invalid packet: %+v", p)
+	batch := make([]*Packet, 0, s.batchSize())
+	for {
+		batch = batch[:0]
+		if len(s.frags) == 0 { // 无待发的分片时阻塞等待
+			p, ok := s.next()
+			if !ok { // 会话已关闭
 				return
 			}
+			batch = append(batch, p)
+		}
+		var ok bool
+		batch, ok = s.collect(batch)
+		if err := s.writeBatch(s.frame(batch)); err != nil {
+			logx.Error("%s -> %s write failed: %v", s.LocalAddr(), s.RemoteAddr(), err)
+			s.Close()
+			return
+		}
+		if !ok {
+			return
+		}
+		s.retryCredits() // 队列已腾出空位
+	}
+}
 
-			n, err := s.cw.Write(buf)
-			if err != nil {
-				logx.Error(err)
-				if err == io.EOF { // 另一端主动关闭
-					s.Close()
-					return
-				}
-				if err == io.ErrShortWrite { // 未写完毕尝试重写
-					s.cw.Write(buf[n:])
-				}
-			}
+func (s *Session) batchSize() int {
+	if s.conf.WriteBatch > 0 {
+		return s.conf.WriteBatch
+	}
+	return DEFAULT_BATCH
+}
 
-			// flush
-			if s.closed || s.cw.Buffered() <= 0 {
-				return
-			}
-			if err := s.cw.Flush(); err != nil {
-				logx.Error("flush failed: %v", err)
-				s.cw.Reset(s.conn)
-				return
+// 继续从各队列取包直到批满、队列为空或超过 WriteDelay
+// 队列已关闭时返回 false
+func (s *Session) collect(batch []*Packet) ([]*Packet, bool) {
+	max := s.batchSize()
+	var deadline <-chan time.Time
+	for {
+		var ok bool
+		if batch, ok = s.schedule(batch, max); !ok {
+			return batch, false
+		}
+		if len(batch) >= max || s.conf.WriteDelay <= 0 || len(s.frags) > 0 { // 有分片待发时不等待
+			return batch, true
+		}
+		if deadline == nil {
+			t := time.NewTimer(s.conf.WriteDelay)
+			defer t.Stop()
+			deadline = t.C
+		}
+		p, ok := s.nextBefore(deadline)
+		if !ok {
+			return batch, false
+		}
+		if p == nil { // 超时
+			return batch, true
+		}
+		batch = append(batch, p)
+	}
+}
+
+// 小于该长度的包数据拷贝到包头之后，避免 writev 的分段过碎
+const VECTOR_COPY_LEN = 512
+
+// 写出已编码的包，之后归还分片的 buffer
+func (s *Session) writeBatch(batch []*Packet) error {
+	defer func() {
+		for _, p := range batch {
+			if p.Header.Flags&FLAG_FRAG != 0 {
+				p.Release()
 			}
 		}
+	}()
+	if ha, ok := s.codec.(headerAppender); ok && s.vectored {
+		return s.writev(ha, batch)
 	}
+
+	for _, p := range batch {
+		buf := s.codec.MarshalPacket(*p)
+		if len(buf) == 0 {
+			logx.Error("invalid packet: %+v", p)
+			continue
+		}
+		_, err := s.cw.Write(buf)
+		if r, ok := s.codec.(bufReleaser); ok { // 已拷贝到写缓冲
+			r.ReleaseBuf(buf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.cw.Flush()
+}
+
+// 包头与小包连续写入 s.hdrs，大包数据作为独立分段，一次 writev 写出
+func (s *Session) writev(ha headerAppender, batch []*Packet) error {
+	hdrs := s.hdrs[:0]
+	var ends []int // 每个大包数据之前 hdrs 的写入位置，包尾紧随其后
+	for _, p := range batch {
+		hdrs = ha.AppendPacketHeader(hdrs, *p)
+		if len(p.Data) < VECTOR_COPY_LEN {
+			hdrs = append(hdrs, p.Data...)
+		} else {
+			ends = append(ends, len(hdrs))
+		}
+		hdrs = ha.AppendPacketTrailer(hdrs, *p)
+	}
+	s.hdrs = hdrs
+
+	vec := s.vec[:0]
+	off := 0
+	i := 0
+	for _, p := range batch {
+		if len(p.Data) < VECTOR_COPY_LEN {
+			continue
+		}
+		vec = append(vec, hdrs[off:ends[i]], p.Data)
+		off = ends[i]
+		i++
+	}
+	if off < len(hdrs) {
+		vec = append(vec, hdrs[off:])
+	}
+	s.vec = vec[:0]
+	_, err := vec.WriteTo(s.conn)
+	return err
 }
 
-// 对外保留的写数据方法
+// 对外保留的写数据方法，控制包走 control 队列，其余走 WriteCh
 func (s *Session) Write(p *Packet) error {
-	if !s.closed {
-		select {
-		case s.WriteCh <- p:
-			return nil
-		default:
-			return errors.New("write channel full")
+	return s.enqueue(p, priorityOf(p), false)
+}
+
+// 阻塞直到写入队列或会话关闭
+func (s *Session) writeWait(p *Packet) error {
+	return s.enqueue(p, priorityOf(p), true)
+}
+
+// 事件循环模式下写入 outq，由 flushDirect 协程写连接，poller 不会阻塞在慢的对端上
+// outq 满时 wait 的包阻塞等待，但控制包不受上限约束：poller 归还窗口时不能阻塞
+func (s *Session) queueDirect(p *Packet, prio Priority, wait bool) error {
+	s.outLock.Lock()
+	defer s.outLock.Unlock()
+	max := s.conf.WriteChanSize
+	for wait && prio != PRIORITY_CONTROL && len(s.outq) >= max && !s.IsClosed() {
+		s.outCond.Wait()
+	}
+	if s.IsClosed() {
+		return errors.New("conn closed")
+	}
+	if !wait && len(s.outq) >= max {
+		return errors.New("write channel full")
+	}
+	s.outq = append(s.outq, p)
+	if !s.flushing {
+		s.flushing = true
+		go s.flushDirect()
+	}
+	return nil
+}
+
+// 按序发送 outq 中的包，队列为空时退出，写失败时关闭会话
+func (s *Session) flushDirect() {
+	var batch []*Packet
+	for {
+		s.outLock.Lock()
+		if len(s.outq) == 0 || s.IsClosed() {
+			s.outq = nil
+			s.flushing = false
+			s.outLock.Unlock()
+			return
 		}
+		batch, s.outq = s.outq, batch[:0]
+		s.outCond.Broadcast()
+		s.outLock.Unlock()
+
+		for i, p := range batch {
+			if err := s.writeDirect(p); err != nil {
+				logx.Error("%s -> %s write failed: %v", s.LocalAddr(), s.RemoteAddr(), err)
+				s.Close()
+				break
+			}
+			batch[i] = nil
+		}
+	}
+}
+
+// 同步写入连接，只由 flushDirect 协程调用
+// 大包的各片连续写出，同一 seq 上的包自然保持顺序
+// 不持有 lock 写入，避免阻塞的写拖住 Close
+func (s *Session) writeDirect(p *Packet) error {
+	if s.IsClosed() {
+		return errors.New("conn closed")
+	}
+	peer := s.PeerFlags()
+	p = s.outbound(p, peer)
+	if s.fragmentable(p, peer) { // 各片依次写入
+		for f := s.newFragment(p); !f.done(); {
+			chunk := f.next(s.conf.FragmentSize)
+			err := s.writeFrame(chunk)
+			chunk.Release()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.writeFrame(p)
+}
+
+func (s *Session) writeFrame(p *Packet) error {
+	buf := s.codec.MarshalPacket(*p)
+	if len(buf) == 0 {
+		return fmt.Errorf("invalid packet: %+v", p)
+	}
+	_, err := s.conn.Write(buf)
+	if r, ok := s.codec.(bufReleaser); ok {
+		r.ReleaseBuf(buf)
 	}
-	return errors.New("conn closed")
+	if err != nil {
+		s.Close()
+	}
+	return err
 }
 
 // 关闭当前连接
+// 关闭 conn 后读协程随之退出并关闭 ReadCh
 func (s *Session) Close() error {
-	if !s.closed {
-		s.closed = true
-		s.conn.Close() // 主动关闭连接
-		close(s.ReadCh)
-		close(s.WriteCh)
-		fmt.Println("session closed")
+	s.doneOnce.Do(func() { close(s.done) })
+	s.sendFlow.close()
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	if s.detach != nil {
+		s.detach()
+	}
+	s.conn.Close() // 主动关闭连接
+	for _, lane := range s.lanes {
+		if lane != nil {
+			close(lane)
+		}
+	}
+	s.lock.Unlock()
+	if s.direct { // 唤醒等待 outq 空位的写
+		s.outLock.Lock()
+		s.outCond.Broadcast()
+		s.outLock.Unlock()
+	}
+
+	fmt.Println("session closed")
+	if s.onClose != nil {
+		s.onClose()
 	}
 	return nil
 }
 
 func (s *Session) IsClosed() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	return s.closed
 }
 
+func (s *Session) IdleDuration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.idle))
+}
+
+// 热更新最大空闲时间，立即按新值重新检查
+func (s *Session) SetIdleDuration(d time.Duration) {
+	atomic.StoreInt64(&s.idle, int64(d))
+	if s.idleTimer != nil {
+		s.idleTimer.Reset(0)
+	}
+}
+
 func (s *Session) LocalAddr() string {
 	return s.conn.LocalAddr().String()
 }
@@ -0,0 +1,175 @@
+package tron
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 返回一对已连接的 TCP 连接
+func tcpPair(tb testing.TB) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	c, err := net.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return c, <-accepted
+}
+
+func newWriteSession(tb testing.TB, conn net.Conn, opts ...ConfigOption) *Session {
+	conf, err := BuildConfig(opts...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	s := NewSession(conn, conf, NewDefaultCodec())
+	go s.daemonWritePacket()
+	return s
+}
+
+func TestSessionCoalescedWritesKeepOrder(t *testing.T) {
+	for _, pipe := range []bool{false, true} {
+		var c, peer net.Conn
+		if pipe { // 不支持 writev，走写缓冲
+			c, peer = net.Pipe()
+		} else {
+			c, peer = tcpPair(t)
+		}
+		s := newWriteSession(t, c, WithWriteDelay(time.Millisecond))
+
+		sizes := []int{0, 10, VECTOR_COPY_LEN - 1, VECTOR_COPY_LEN, 100 * 1024, 3, 64 * 1024}
+		var want []*Packet
+		for i := 0; i < 50; i++ {
+			size := sizes[i%len(sizes)]
+			p := NewRespPacket(int32(i), bytes.Repeat([]byte{byte(i)}, size))
+			want = append(want, p)
+			s.WriteCh <- p
+		}
+
+		codec := NewDefaultCodec()
+		r := bufio.NewReader(peer)
+		for i, w := range want {
+			b, err := codec.ReadPacket(r)
+			if err != nil {
+				t.Fatalf("pipe=%v packet %d: %v", pipe, i, err)
+			}
+			p, err := codec.UnmarshalPacket(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Header.Seq != w.Header.Seq || !bytes.Equal(p.Data, w.Data) {
+				t.Fatalf("pipe=%v packet %d: got seq %d len %d, want seq %d len %d",
+					pipe, i, p.Header.Seq, len(p.Data), w.Header.Seq, len(w.Data))
+			}
+			p.Release()
+		}
+		s.Close()
+		peer.Close()
+	}
+}
+
+// 小包吞吐：持续写入 64 字节的包，对端只计数丢弃
+func benchmarkSessionWrite(b *testing.B, opts ...ConfigOption) {
+	c, peer := tcpPair(b)
+	defer peer.Close()
+	s := newWriteSession(b, c, append([]ConfigOption{WithWriteChanSize(1024)}, opts...)...)
+	defer s.Close()
+
+	data := bytes.Repeat([]byte("x"), 64)
+	frame := int64(PACK_LEN + HEADER_LEN + len(data))
+	total := frame * int64(b.N)
+	var read int64
+	done := make(chan struct{})
+	go func() {
+		n, _ := io.CopyN(io.Discard, peer, total)
+		atomic.StoreInt64(&read, n)
+		close(done)
+	}()
+
+	b.ReportAllocs()
+	b.SetBytes(frame)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.WriteCh <- NewRespPacket(int32(i), data)
+	}
+	<-done
+	if n := atomic.LoadInt64(&read); n != total {
+		b.Fatalf("peer read %d bytes, want %d", n, total)
+	}
+}
+
+func BenchmarkSessionWriteSmall(b *testing.B)        { benchmarkSessionWrite(b) }
+func BenchmarkSessionWriteSmallNoBatch(b *testing.B) { benchmarkSessionWrite(b, WithWriteBatch(1)) }
+func BenchmarkSessionWriteSmallDelay(b *testing.B) {
+	benchmarkSessionWrite(b, WithWriteDelay(50*time.Microsecond))
+}
+
+// 各队列积压时按优先级与权重交错写出，同一队列内保持顺序
+func TestSessionPriorityLanes(t *testing.T) {
+	c, peer := tcpPair(t)
+	defer peer.Close()
+	conf, err := BuildConfig(WithWriteChanSize(256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSession(c, conf, NewDefaultCodec())
+	defer s.Close()
+
+	counts := map[Priority]int{PRIORITY_BULK: 200, PRIORITY_NORMAL: 20, PRIORITY_HIGH: 5, PRIORITY_CONTROL: 1}
+	for _, prio := range []Priority{PRIORITY_BULK, PRIORITY_NORMAL, PRIORITY_HIGH, PRIORITY_CONTROL} {
+		for i := 0; i < counts[prio]; i++ {
+			if err := s.WritePriority(NewRespPacket(int32(prio)*1000+int32(i), nil), prio); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := s.WritePriority(NewRespPacket(0, nil), PRIORITY_LANES); err != ERR_INVALID_PRIORITY {
+		t.Fatalf("want ERR_INVALID_PRIORITY, got %v", err)
+	}
+	go s.daemonWritePacket()
+
+	codec := NewDefaultCodec()
+	r := bufio.NewReader(peer)
+	next := map[Priority]int{}
+	lastNormal, firstBulk := -1, -1
+	for i := 0; i < 226; i++ {
+		b, err := codec.ReadPacket(r)
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		p, err := codec.UnmarshalPacket(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prio, n := Priority(p.Header.Seq/1000), int(p.Header.Seq%1000)
+		if n != next[prio] {
+			t.Fatalf("%v lane out of order: got %d, want %d", prio, n, next[prio])
+		}
+		next[prio]++
+		switch {
+		case i == 0 && prio != PRIORITY_CONTROL:
+			t.Fatalf("first packet is %v, want control", prio)
+		case i >= 1 && i <= 5 && prio != PRIORITY_HIGH:
+			t.Fatalf("packet %d is %v, want high", i, prio)
+		case prio == PRIORITY_NORMAL:
+			lastNormal = i
+		case prio == PRIORITY_BULK && firstBulk < 0:
+			firstBulk = i
+		}
+	}
+	if firstBulk > lastNormal || lastNormal > 40 {
+		t.Fatalf("bulk should interleave with normal: first bulk %d, last normal %d", firstBulk, lastNormal)
+	}
+}
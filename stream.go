@@ -0,0 +1,395 @@
+package tron
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"logx"
+	"sync"
+)
+
+var (
+	ERR_STREAM_UNSUPPORTED = errors.New("stream unsupported by peer")
+	ERR_STREAM_CLOSED      = errors.New("stream closed: connection lost")
+	ERR_STREAM_SEND_CLOSED = errors.New("stream send side closed")
+	ERR_STREAM_WORKER      = errors.New("stream can only be opened by client") // 两端的流 id 会在同一连接上冲突
+)
+
+// 流的结束状态码，0 表示成功，其余由业务自定义
+const (
+	STATUS_OK            int32 = 0
+	STATUS_UNIMPLEMENTED int32 = 1 // server 未设置流处理函数
+)
+
+// 对端以非 STATUS_OK 结束流
+type StreamError struct {
+	Code    int32
+	Message string
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("stream status %d: %s", e.Code, e.Message)
+}
+
+// 同一 seq 上的双向多消息交互，两端各自发送 FIN 半关闭
+// 接收按迭代器使用：
+//
+//	for st.Next() {
+//		handle(st.Data())
+//	}
+//	if err := st.Err(); err != nil { ... }
+type Stream struct {
+	id       int32
+	client   *Client
+	lock     sync.Mutex
+	cond     *sync.Cond
+	queue    []*Packet // 已收到未读取的消息
+	cur      *Packet   // Data 返回的当前消息
+	recvDone bool      // 已收到对端的 FIN
+	sendDone bool      // 已发送 FIN
+	status   *StreamError
+	err      error // 连接断开
+	discard  bool  // 处理函数已返回，之后收到的消息直接丢弃
+
+	sendFlow   *flow  // 对端为本流开放的发送窗口
+	recvCredit credit // 已读取待归还的接收窗口
+}
+
+func newStream(id int32, c *Client) *Stream {
+	st := &Stream{id: id, client: c, sendFlow: newFlow(INITIAL_WINDOW)}
+	st.cond = sync.NewCond(&st.lock)
+	return st
+}
+
+// 流 id，即打开流时分配的 seq
+func (st *Stream) ID() int32 {
+	return st.id
+}
+
+// 打开一个流，首次调用时与 server 协商
+// 旧版本 server 不回复协商，返回 ctx 的错误，未设置截止时间时 HELLO_TIMEOUT 后返回 context.DeadlineExceeded
+// 只能由 client 打开，server 的 worker 返回 ERR_STREAM_WORKER
+func (c *Client) OpenStream(ctx context.Context) (*Stream, error) {
+	if c.worker {
+		return nil, ERR_STREAM_WORKER
+	}
+	if c.session.PeerFlags()&FLAG_STREAM == 0 {
+		if err := c.session.negotiate(ctx); err != nil {
+			return nil, err
+		}
+		if c.session.PeerFlags()&FLAG_STREAM == 0 {
+			return nil, ERR_STREAM_UNSUPPORTED
+		}
+	}
+
+	c.streamLock.Lock()
+	if c.streams == nil {
+		c.streams = make(map[int32]*Stream)
+	}
+	id := c.conf.SeqManager.NextSeq()
+	for _, ok := c.streams[id]; ok || id < 0; _, ok = c.streams[id] { // 跳过仍在使用的 seq
+		id = c.conf.SeqManager.NextSeq()
+	}
+	st := newStream(id, c)
+	c.streams[id] = st
+	c.streamLock.Unlock()
+
+	if err := c.session.writeWait(st.frame(FLAG_STREAM_OPEN, nil)); err != nil {
+		c.removeStream(st)
+		return nil, err
+	}
+	st.growWindow()
+	return st, nil
+}
+
+// 流建立后补足接收窗口
+// 与 OPEN 走同一队列，避免先于 OPEN 到达而被对端丢弃
+func (st *Stream) growWindow() {
+	s := st.client.session
+	if s.flowControlled() && st.client.conf.StreamWindow > INITIAL_WINDOW {
+		inc := int64(st.client.conf.StreamWindow - INITIAL_WINDOW)
+		st.recvCredit.grow(inc)
+		s.enqueue(NewWindowPacket(st.id, inc), PRIORITY_NORMAL, true)
+	}
+}
+
+// 收到的消息占用接收窗口，对端超出窗口发送时返回 error，需关闭会话
+func (st *Stream) admit(n int) error {
+	if !st.client.session.flowControlled() || st.recvCredit.receive(int64(n)) {
+		return nil
+	}
+	return &ProtocolError{fmt.Errorf("%w: stream %d", ERR_WINDOW_EXCEEDED, st.id)}
+}
+
+// 归还已读取的接收窗口，不阻塞，可在分发流程中调用
+func (st *Stream) ack(n int) {
+	s := st.client.session
+	if !s.flowControlled() {
+		return
+	}
+	if inc := st.recvCredit.consume(int64(n), st.client.conf.StreamWindow); inc > 0 {
+		s.sendCredit(st.id, inc)
+	}
+}
+
+func (st *Stream) frame(flags uint32, data []byte) *Packet {
+	p := NewRespPacket(st.id, data)
+	p.Header.Flags = FLAG_STREAM | flags
+	return p
+}
+
+// 发送一条消息，WriteCh 已满或对端未读取而窗口耗尽时阻塞
+func (st *Stream) Send(data []byte) error {
+	st.lock.Lock()
+	err := st.err
+	if st.sendDone {
+		err = ERR_STREAM_SEND_CLOSED
+	}
+	st.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s := st.client.session
+	if s.flowControlled() { // 先占流窗口，慢的流不会占住连接窗口
+		n := int64(len(data))
+		if !st.sendFlow.acquire(n) || !s.sendFlow.acquire(n) {
+			return ERR_STREAM_CLOSED
+		}
+	}
+	return s.writeWait(st.frame(0, data))
+}
+
+// 半关闭，不再发送消息，对端的 Next 随后返回 false
+func (st *Stream) CloseSend() error {
+	return st.Finish(STATUS_OK, "")
+}
+
+// 以指定状态半关闭，通常由 server 在流处理结束时调用，重复调用无效果
+func (st *Stream) Finish(code int32, msg string) error {
+	st.lock.Lock()
+	if st.sendDone || st.err != nil {
+		st.lock.Unlock()
+		return st.err
+	}
+	st.sendDone = true
+	recvDone := st.recvDone
+	st.lock.Unlock()
+
+	data := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(data, uint32(code))
+	copy(data[4:], msg)
+	err := st.client.session.writeWait(st.frame(FLAG_STREAM_FIN, data))
+	if recvDone {
+		st.client.removeStream(st)
+	}
+	return err
+}
+
+// 阻塞等待下一条消息，流结束或连接断开时返回 false
+// 上一条消息的 Data 随之失效
+func (st *Stream) Next() bool {
+	st.lock.Lock()
+	if st.cur != nil {
+		st.cur.Release()
+		st.cur = nil
+	}
+	for len(st.queue) == 0 && !st.recvDone && st.err == nil {
+		st.cond.Wait()
+	}
+	if len(st.queue) == 0 {
+		st.lock.Unlock()
+		return false
+	}
+	st.cur = st.queue[0]
+	st.queue[0] = nil
+	st.queue = st.queue[1:]
+	n, recvDone := len(st.cur.Data), st.recvDone
+	st.lock.Unlock()
+
+	if !recvDone {
+		st.ack(n)
+	}
+	return true
+}
+
+// 当前消息的数据
+func (st *Stream) Data() []byte {
+	if st.cur == nil {
+		return nil
+	}
+	return st.cur.Data
+}
+
+// Next 返回 false 后调用，正常结束时返回 nil
+// 对端以非 STATUS_OK 结束时返回 *StreamError
+func (st *Stream) Err() error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if st.err != nil {
+		return st.err
+	}
+	if st.status != nil {
+		return st.status
+	}
+	return nil
+}
+
+func (st *Stream) receive(p *Packet) {
+	st.lock.Lock()
+	if st.discard {
+		st.lock.Unlock()
+		n := len(p.Data)
+		p.Release()
+		st.ack(n)
+		return
+	}
+	defer st.lock.Unlock()
+	if st.recvDone || st.err != nil {
+		p.Release()
+		return
+	}
+	st.queue = append(st.queue, p)
+	st.cond.Signal()
+}
+
+// 处理函数返回后丢弃未读取的消息并归还窗口，避免对端的 Send 永久阻塞
+func (st *Stream) drain() {
+	st.lock.Lock()
+	st.discard = true
+	queue := st.queue
+	st.queue = nil
+	if st.cur != nil {
+		queue = append(queue, st.cur)
+		st.cur = nil
+	}
+	st.lock.Unlock()
+
+	n := 0
+	for _, p := range queue {
+		n += len(p.Data)
+		p.Release()
+	}
+	st.ack(n)
+}
+
+// 收到 FIN，返回流是否已两端关闭
+func (st *Stream) finish(data []byte) bool {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.recvDone = true
+	if len(data) >= 4 {
+		if code := int32(binary.BigEndian.Uint32(data)); code != STATUS_OK {
+			st.status = &StreamError{Code: code, Message: string(data[4:])}
+		}
+	}
+	st.cond.Broadcast()
+	return st.sendDone
+}
+
+func (st *Stream) fail(err error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if !st.recvDone || !st.sendDone {
+		st.err = err
+	}
+	st.cond.Broadcast()
+	st.sendFlow.close()
+}
+
+// 分发流上的消息，在 handle 协程中按序执行，事件循环模式下在 poller 中执行
+func (c *Client) dispatchStream(p *Packet) {
+	flags := p.Header.Flags
+	c.streamLock.Lock()
+	st := c.streams[p.Header.Seq]
+	if st == nil && flags&FLAG_STREAM_OPEN != 0 {
+		if c.streamHandler == nil {
+			c.streamLock.Unlock()
+			fin := NewRespPacket(p.Header.Seq, []byte("\x00\x00\x00\x01no stream handler"))
+			fin.Header.Flags = FLAG_STREAM | FLAG_STREAM_FIN
+			c.session.Write(fin)
+			p.Release()
+			return
+		}
+		if c.streams == nil {
+			c.streams = make(map[int32]*Stream)
+		}
+		st = newStream(p.Header.Seq, c)
+		c.streams[st.id] = st
+		go func() {
+			st.growWindow()
+			c.streamHandler(st)
+			st.CloseSend() // 处理函数未结束流时以 STATUS_OK 结束
+			st.drain()
+		}()
+	}
+	c.streamLock.Unlock()
+
+	if st == nil { // 已结束的流
+		p.Release()
+		return
+	}
+	switch {
+	case flags&FLAG_WINDOW != 0:
+		st.sendFlow.grant(parseWindow(p.Data))
+		p.Release()
+	case flags&FLAG_STREAM_FIN != 0:
+		if st.finish(p.Data) {
+			c.removeStream(st)
+		}
+		p.Release()
+	case flags&FLAG_STREAM_OPEN != 0:
+		p.Release()
+	default:
+		if err := c.admitStream(st, len(p.Data)); err != nil {
+			p.Release()
+			return
+		}
+		st.receive(p)
+	}
+}
+
+// 流消息被限速丢弃时归还其占用的连接与流窗口，避免对端的 Send 永久阻塞
+func (c *Client) discardStream(p *Packet) {
+	n := len(p.Data)
+	c.streamLock.Lock()
+	st := c.streams[p.Header.Seq]
+	c.streamLock.Unlock()
+	if err := c.admitStream(st, n); err == nil && st != nil {
+		st.ack(n)
+	}
+}
+
+// 流上的数据占用连接与流的接收窗口，对端不理会窗口时关闭会话，避免积压的消息耗尽内存
+// st 为 nil 时只占用连接窗口
+func (c *Client) admitStream(st *Stream, n int) error {
+	err := c.session.consumeConn(n)
+	if err == nil && st != nil {
+		err = st.admit(n)
+	}
+	if err != nil {
+		logx.Error("%s -> %s %v", c.LocalAddr(), c.RemoteAddr(), err)
+		c.session.Close()
+	}
+	return err
+}
+
+func (c *Client) removeStream(st *Stream) {
+	c.streamLock.Lock()
+	if c.streams[st.id] == st {
+		delete(c.streams, st.id)
+	}
+	c.streamLock.Unlock()
+}
+
+// 会话关闭时结束所有流
+func (c *Client) failStreams(err error) {
+	c.streamLock.Lock()
+	streams := c.streams
+	c.streams = nil
+	c.streamLock.Unlock()
+	for _, st := range streams {
+		st.fail(err)
+	}
+}
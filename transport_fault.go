@@ -0,0 +1,197 @@
+package tron
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+var ERR_FAULT_RESET = errors.New("fault: connection reset")
+
+// 故障注入配置，零值表示不注入对应故障
+type FaultConfig struct {
+	Latency      time.Duration // 每次写入底层连接前的固定延迟
+	Jitter       time.Duration // 在 Latency 基础上随机增加 [0, Jitter) 的延迟
+	WriteChunk   int           // 将一次写入拆成多个该大小的分片依次写入
+	PartialWrite int           // 单次 Write 最多写入的字节数，超出部分返回 io.ErrShortWrite
+	ReadChunk    int           // 单次 Read 最多返回的字节数
+	ResetAfter   int64         // 累计读写超过该字节数后重置连接
+	StallAfter   int64         // 累计读取超过该字节数后读阻塞
+	StallFor     time.Duration // 读阻塞时长，0 则阻塞到连接关闭
+	Seed         int64         // 随机延迟的种子，便于复现
+}
+
+// 注入故障的连接，可包装任意 net.Conn 后交给 NewClient / NewSession 使用
+type FaultConn struct {
+	net.Conn
+	conf    FaultConfig
+	rnd     *rand.Rand
+	lock    sync.Mutex
+	read    int64 // 已读字节数
+	written int64 // 已写字节数
+	stalled bool
+	closeCh chan struct{}
+	once    sync.Once
+}
+
+func NewFaultConn(conn net.Conn, conf FaultConfig) *FaultConn {
+	c := &FaultConn{
+		Conn:    conn,
+		conf:    conf,
+		rnd:     rand.New(rand.NewSource(conf.Seed)),
+		closeCh: make(chan struct{}),
+	}
+	return c
+}
+
+func (c *FaultConn) Read(b []byte) (int, error) {
+	if err := c.maybeStall(); err != nil {
+		return 0, err
+	}
+	if c.conf.ReadChunk > 0 && len(b) > c.conf.ReadChunk { // 字节级分片读取
+		b = b[:c.conf.ReadChunk]
+	}
+	n, err := c.Conn.Read(b)
+
+	c.lock.Lock()
+	c.read += int64(n)
+	reset := c.overReset()
+	c.lock.Unlock()
+	if reset {
+		c.reset()
+		return n, ERR_FAULT_RESET
+	}
+	return n, err
+}
+
+func (c *FaultConn) Write(b []byte) (int, error) {
+	var short bool
+	if c.conf.PartialWrite > 0 && len(b) > c.conf.PartialWrite {
+		b = b[:c.conf.PartialWrite]
+		short = true
+	}
+
+	chunk := len(b)
+	if c.conf.WriteChunk > 0 {
+		chunk = c.conf.WriteChunk
+	}
+
+	total := 0
+	for total < len(b) {
+		end := total + chunk
+		if end > len(b) {
+			end = len(b)
+		}
+
+		// 连接重置前最多写到阈值处
+		c.lock.Lock()
+		if c.conf.ResetAfter > 0 && c.read+c.written+int64(end-total) > c.conf.ResetAfter {
+			end = total + int(c.conf.ResetAfter-c.read-c.written)
+		}
+		c.lock.Unlock()
+		if end <= total {
+			c.reset()
+			return total, ERR_FAULT_RESET
+		}
+
+		c.delay()
+		n, err := c.Conn.Write(b[total:end])
+		total += n
+
+		c.lock.Lock()
+		c.written += int64(n)
+		c.lock.Unlock()
+		if err != nil {
+			return total, err
+		}
+	}
+
+	if short {
+		return total, io.ErrShortWrite
+	}
+	return total, nil
+}
+
+func (c *FaultConn) Close() error {
+	c.once.Do(func() {
+		close(c.closeCh)
+	})
+	return c.Conn.Close()
+}
+
+// 写入前的延迟与抖动
+func (c *FaultConn) delay() {
+	d := c.conf.Latency
+	if c.conf.Jitter > 0 {
+		c.lock.Lock()
+		d += time.Duration(c.rnd.Int63n(int64(c.conf.Jitter)))
+		c.lock.Unlock()
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// 读取量达到阈值后阻塞一次
+func (c *FaultConn) maybeStall() error {
+	c.lock.Lock()
+	stall := !c.stalled && c.conf.StallAfter > 0 && c.read >= c.conf.StallAfter
+	if stall {
+		c.stalled = true
+	}
+	c.lock.Unlock()
+	if !stall {
+		return nil
+	}
+
+	if c.conf.StallFor <= 0 {
+		<-c.closeCh
+		return net.ErrClosed
+	}
+	select {
+	case <-time.After(c.conf.StallFor):
+		return nil
+	case <-c.closeCh:
+		return net.ErrClosed
+	}
+}
+
+func (c *FaultConn) overReset() bool {
+	return c.conf.ResetAfter > 0 && c.read+c.written >= c.conf.ResetAfter
+}
+
+func (c *FaultConn) reset() {
+	if tcpConn, ok := c.Conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0) // 发送 RST 而非 FIN
+	}
+	c.Close()
+}
+
+// 为每个新连接注入故障的 listener，可交给 Server.Serve 使用
+type FaultListener struct {
+	net.Listener
+	confFn func(conn net.Conn) *FaultConfig
+}
+
+// confFn 返回 nil 表示该连接不注入故障
+func NewFaultListener(l net.Listener, confFn func(conn net.Conn) *FaultConfig) *FaultListener {
+	return &FaultListener{
+		Listener: l,
+		confFn:   confFn,
+	}
+}
+
+func (l *FaultListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	conf := l.confFn(conn)
+	if conf == nil {
+		return conn, nil
+	}
+	return NewFaultConn(conn, *conf), nil
+}
@@ -0,0 +1,90 @@
+package tron
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// 进程内的内存管道 listener，Server 与 Client 无需绑定真实端口
+// 连接两端依旧走完整的 codec 与 session 读写流程，主要用于测试
+type LoopbackListener struct {
+	name    string
+	connCh  chan net.Conn
+	closeCh chan struct{}
+	once    sync.Once
+	nextId  int32
+}
+
+func NewLoopbackListener(name string) *LoopbackListener {
+	l := &LoopbackListener{
+		name:    name,
+		connCh:  make(chan net.Conn),
+		closeCh: make(chan struct{}),
+	}
+	return l
+}
+
+// 等待 Dial 建立的新连接
+func (l *LoopbackListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.closeCh:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *LoopbackListener) Close() error {
+	l.once.Do(func() {
+		close(l.closeCh)
+	})
+	return nil
+}
+
+func (l *LoopbackListener) Addr() net.Addr {
+	return loopbackAddr(l.name)
+}
+
+// 建立一对内存管道，一端交给 Accept，另一端返回给 client
+func (l *LoopbackListener) Dial() (net.Conn, error) {
+	id := atomic.AddInt32(&l.nextId, 1)
+	serverAddr := loopbackAddr(l.name)
+	clientAddr := loopbackAddr(fmt.Sprintf("%s:%d", l.name, id)) // 区分不同 client 的地址
+
+	serverEnd, clientEnd := net.Pipe()
+	select {
+	case l.connCh <- &loopbackConn{Conn: serverEnd, local: serverAddr, remote: clientAddr}:
+		return &loopbackConn{Conn: clientEnd, local: clientAddr, remote: serverAddr}, nil
+	case <-l.closeCh:
+		serverEnd.Close()
+		clientEnd.Close()
+		return nil, net.ErrClosed
+	}
+}
+
+// 覆盖 net.Pipe 统一的 "pipe" 地址，使 ClientsManager 能按地址区分连接
+type loopbackConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *loopbackConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *loopbackConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+type loopbackAddr string
+
+func (a loopbackAddr) Network() string {
+	return "loopback"
+}
+
+func (a loopbackAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,122 @@
+package trontest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tron"
+)
+
+// 记录 client 写出的 hello 包，旧版本 server 会将其当作普通请求
+type helloConn struct {
+	net.Conn
+	hellos *int64
+}
+
+func (c helloConn) Write(b []byte) (int, error) {
+	if bytes.Contains(b, []byte("TRON")) {
+		atomic.AddInt64(c.hellos, 1)
+	}
+	return c.Conn.Write(b)
+}
+
+func TestSyncWriteBatch(t *testing.T) {
+	for _, batch := range []bool{false, true} { // 开启 Batch 时 Dial 协商 FLAG_BATCH，否则逐个发送且不发 hello
+		var handled, hellos int64
+		pair, err := NewPair(func(worker *tron.Client, p *tron.Packet) {
+			atomic.AddInt64(&handled, 1)
+			if !strings.HasPrefix(string(p.Data), "drop") { // 不回复的项以超时结束
+				EchoHandler(worker, p)
+			}
+		}, NotifyHandler)
+		if err != nil {
+			t.Fatalf("new pair failed: %v", err)
+		}
+		conf, err := tron.BuildConfig(tron.WithBatch(batch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cli, err := tron.Dial(context.Background(), pair.Listener.Addr().String(),
+			tron.WithClientConfig(conf),
+			tron.WithClientHandler(NotifyHandler),
+			tron.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := pair.Listener.Dial()
+				return helloConn{conn, &hellos}, err
+			}),
+		)
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		var reqs []*tron.Packet
+		for i := 0; i < 40; i++ {
+			data := fmt.Sprintf("req-%d", i)
+			if i%10 == 9 {
+				data = fmt.Sprintf("drop-%d", i)
+			}
+			reqs = append(reqs, tron.NewReqPacket([]byte(data)))
+		}
+		reqs = append(reqs, tron.NewOneWayPacket([]byte("drop-event")))
+		results, err := cli.SyncWriteBatch(reqs, 200*time.Millisecond)
+		if err != nil {
+			t.Fatalf("sync write batch failed: %v", err)
+		}
+		for i, r := range results[:40] {
+			if i%10 == 9 {
+				if r.Err != tron.ERR_BATCH_TIMEOUT {
+					t.Fatalf("batch=%v item %d: want timeout, got %v", batch, i, r.Err)
+				}
+				continue
+			}
+			if r.Err != nil || string(r.Resp.([]byte)) != fmt.Sprintf("req-%d", i) {
+				t.Fatalf("batch=%v item %d: got %v %v", batch, i, r.Resp, r.Err)
+			}
+		}
+		if n := atomic.LoadInt64(&handled); n != 41 {
+			t.Fatalf("batch=%v: server handled %d requests, want 41", batch, n)
+		}
+		if n := atomic.LoadInt64(&hellos); (n != 0) != batch {
+			t.Fatalf("batch=%v: sent %d hello packets", batch, n)
+		}
+		if n := conf.SeqManager.Pending(); n != 0 {
+			t.Fatalf("batch=%v: %d seqs pending after batch", batch, n)
+		}
+		pair.Close()
+	}
+}
+
+// 响应为 nil 的项不被当作超时
+func TestSyncWriteBatchNilResponse(t *testing.T) {
+	pair, err := NewPair(EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+	conf, err := tron.BuildConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := dialConf(t, pair, conf, func(cli *tron.Client, p *tron.Packet) {
+		if len(p.Data) == 0 {
+			cli.NotifyReceived(p.Header.Seq, nil)
+			return
+		}
+		NotifyHandler(cli, p)
+	})
+
+	reqs := []*tron.Packet{tron.NewReqPacket(nil), tron.NewReqPacket([]byte("req"))}
+	results, err := cli.SyncWriteBatch(reqs, time.Second)
+	if err != nil {
+		t.Fatalf("sync write batch failed: %v", err)
+	}
+	if results[0].Err != nil || results[0].Resp != nil {
+		t.Fatalf("nil response: got %v %v", results[0].Resp, results[0].Err)
+	}
+	if results[1].Err != nil || string(results[1].Resp.([]byte)) != "req" {
+		t.Fatalf("item 1: got %v %v", results[1].Resp, results[1].Err)
+	}
+}
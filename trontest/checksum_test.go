@@ -0,0 +1,160 @@
+package trontest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tron"
+)
+
+// 模拟有问题的中间设备，翻转带有 marker 的写入中的一个字节
+type corruptConn struct {
+	net.Conn
+	marker []byte
+}
+
+func (c corruptConn) Write(b []byte) (int, error) {
+	if i := bytes.Index(b, c.marker); i >= 0 {
+		b = append([]byte(nil), b...)
+		b[i] ^= 0xff
+	}
+	return c.Conn.Write(b)
+}
+
+// 模拟篡改了 flags 的中间设备，去掉带有 marker 的包上的校验和并改写数据
+type stripConn struct {
+	net.Conn
+	marker []byte
+}
+
+func (c stripConn) Write(b []byte) (int, error) {
+	if bytes.Index(b, c.marker) < 0 {
+		return c.Conn.Write(b)
+	}
+	codec := tron.NewDefaultCodec()
+	var out []byte
+	for rest := b; len(rest) >= 4; {
+		n := 4 + int(binary.BigEndian.Uint32(rest))
+		frame := rest[:n]
+		rest = rest[n:]
+		if bytes.Index(frame, c.marker) < 0 {
+			out = append(out, frame...)
+			continue
+		}
+		p, err := codec.UnmarshalPacket(append([]byte(nil), frame[4:]...))
+		if err != nil {
+			return 0, err
+		}
+		p.Header.Flags &^= tron.FLAG_CRC
+		p.Data = bytes.Replace(p.Data, c.marker, bytes.ToUpper(c.marker), 1)
+		out = append(out, codec.MarshalPacket(*p)...)
+	}
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// 协商校验和后，去掉校验和的包不会交给 handler，并计入 ChecksumErrors
+func TestChecksumRequiredAfterNegotiation(t *testing.T) {
+	conf, err := tron.BuildConfig(tron.WithChecksum(true))
+	if err != nil {
+		t.Fatalf("build config failed: %v", err)
+	}
+	var delivered, crcErrors uint64
+	handler := func(worker *tron.Client, p *tron.Packet) {
+		if bytes.Contains(bytes.ToLower(p.Data), []byte("strip-me")) {
+			atomic.AddUint64(&delivered, 1)
+		}
+		atomic.StoreUint64(&crcErrors, worker.ChecksumErrors())
+		EchoHandler(worker, p)
+	}
+	pair, err := NewPairWithOptions([]tron.ServerOption{tron.WithServerConfig(conf)}, handler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	cli, err := tron.Dial(context.Background(), pair.Listener.Addr().String(),
+		tron.WithClientHandler(NotifyHandler),
+		tron.WithClientConfig(conf),
+		tron.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := pair.Listener.Dial()
+			return stripConn{conn, []byte("strip-me")}, err
+		}),
+	)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	if _, err := cli.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second); err != nil {
+		t.Fatalf("sync write failed: %v", err)
+	}
+	if _, err := cli.SyncWrite(tron.NewReqPacket([]byte("strip-me")), 100*time.Millisecond); err == nil {
+		t.Fatalf("packet without checksum delivered")
+	}
+	if _, err := cli.SyncWrite(tron.NewReqPacket([]byte("pong")), time.Second); err != nil {
+		t.Fatalf("sync write after dropped packet failed: %v", err)
+	}
+	if n := atomic.LoadUint64(&delivered); n != 0 {
+		t.Fatalf("handler got %d packets without checksum", n)
+	}
+	if n := atomic.LoadUint64(&crcErrors); n != 1 {
+		t.Fatalf("checksum errors: got %d, want 1", n)
+	}
+}
+
+func TestChecksumPolicy(t *testing.T) {
+	for _, policy := range []tron.ChecksumPolicy{tron.CHECKSUM_DROP, tron.CHECKSUM_CLOSE} {
+		t.Run(policy.String(), func(t *testing.T) {
+			conf, err := tron.BuildConfig(tron.WithChecksum(true), tron.WithChecksumPolicy(policy))
+			if err != nil {
+				t.Fatalf("build config failed: %v", err)
+			}
+			var crcErrors uint64
+			handler := func(worker *tron.Client, p *tron.Packet) {
+				atomic.StoreUint64(&crcErrors, worker.ChecksumErrors())
+				EchoHandler(worker, p)
+			}
+			pair, err := NewPairWithOptions([]tron.ServerOption{tron.WithServerConfig(conf)}, handler, NotifyHandler)
+			if err != nil {
+				t.Fatalf("new pair failed: %v", err)
+			}
+			defer pair.Close()
+
+			cli, err := tron.Dial(context.Background(), pair.Listener.Addr().String(),
+				tron.WithClientHandler(NotifyHandler),
+				tron.WithClientConfig(conf),
+				tron.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+					conn, err := pair.Listener.Dial()
+					return corruptConn{conn, []byte("corrupt-me")}, err
+				}),
+			)
+			if err != nil {
+				t.Fatalf("dial failed: %v", err)
+			}
+			if _, err := cli.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second); err != nil {
+				t.Fatalf("sync write failed: %v", err)
+			}
+			if _, err := cli.SyncWrite(tron.NewReqPacket([]byte("corrupt-me")), 100*time.Millisecond); err == nil {
+				t.Fatalf("corrupted request delivered")
+			}
+
+			if policy == tron.CHECKSUM_CLOSE {
+				if !cli.IsClosed() {
+					t.Fatalf("session not closed on checksum mismatch")
+				}
+				return
+			}
+			if _, err := cli.SyncWrite(tron.NewReqPacket([]byte("pong")), time.Second); err != nil {
+				t.Fatalf("sync write after dropped packet failed: %v", err)
+			}
+			if n := atomic.LoadUint64(&crcErrors); n != 1 {
+				t.Fatalf("checksum errors: got %d, want 1", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package trontest
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+	"tron"
+)
+
+func TestHostilePacketLenClosesOnlyOffender(t *testing.T) {
+	pair, err := NewPair(EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	for _, packLen := range []int32{1 << 30, -1} {
+		conn, err := pair.Listener.Dial()
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		prefix := make([]byte, tron.PACK_LEN)
+		binary.BigEndian.PutUint32(prefix, uint32(packLen))
+		if _, err := conn.Write(prefix); err != nil {
+			t.Fatalf("write prefix failed: %v", err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("packet len %d: hostile conn should be closed, got %v", packLen, err)
+		}
+		conn.Close()
+	}
+
+	if _, err := pair.Client.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second); err != nil {
+		t.Fatalf("well-behaved client affected: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package trontest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tron"
+)
+
+// 统计读取的字节数
+type countConn struct {
+	net.Conn
+	read *int64
+}
+
+func (c countConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(c.read, int64(n))
+	return n, err
+}
+
+func compressConf(t *testing.T, c tron.Compression) *tron.Config {
+	conf, err := tron.BuildConfig(tron.WithCompression(c))
+	if err != nil {
+		t.Fatalf("build config failed: %v", err)
+	}
+	return conf
+}
+
+func TestCompressionNegotiated(t *testing.T) {
+	opts := []tron.ServerOption{tron.WithServerConfig(compressConf(t, tron.COMPRESS_GZIP))}
+	pair, err := NewPairWithOptions(opts, EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	var read int64
+	cli, err := tron.Dial(context.Background(), pair.Listener.Addr().String(),
+		tron.WithClientHandler(NotifyHandler),
+		tron.WithClientConfig(compressConf(t, tron.COMPRESS_DEFLATE)),
+		tron.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := pair.Listener.Dial()
+			return countConn{conn, &read}, err
+		}),
+	)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+
+	data := bytes.Repeat([]byte(`{"user":"tron","tags":["a","b"]},`), 1000)
+	for i := 0; i < 3; i++ {
+		resp, err := cli.SyncWrite(tron.NewReqPacket(data), time.Second)
+		if err != nil {
+			t.Fatalf("sync write failed: %v", err)
+		}
+		if !bytes.Equal(resp.([]byte), data) {
+			t.Fatalf("echo mismatch")
+		}
+	}
+	if n := atomic.LoadInt64(&read); n >= int64(len(data)) {
+		t.Fatalf("responses not compressed: read %d bytes for 3 x %d", n, len(data))
+	}
+}
+
+// 模拟旧版本 server：原样回显所有包，包括 hello
+func TestCompressionOldServer(t *testing.T) {
+	l := tron.NewLoopbackListener("old")
+	defer l.Close()
+	flagsSeen := make(chan uint32, 16)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		codec := tron.NewDefaultCodec()
+		r := bufio.NewReader(conn)
+		for {
+			b, err := codec.ReadPacket(r)
+			if err != nil {
+				return
+			}
+			p, err := codec.UnmarshalPacket(b)
+			if err != nil {
+				return
+			}
+			flagsSeen <- p.Header.Flags
+			conn.Write(codec.MarshalPacket(*p))
+		}
+	}()
+
+	cli, err := tron.Dial(context.Background(), l.Addr().String(),
+		tron.WithClientHandler(NotifyHandler),
+		tron.WithClientConfig(compressConf(t, tron.COMPRESS_GZIP)),
+		tron.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return l.Dial()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("payload "), 1000)
+	for i := 0; i < 2; i++ {
+		resp, err := cli.SyncWrite(tron.NewReqPacket(data), time.Second)
+		if err != nil {
+			t.Fatalf("sync write failed: %v", err)
+		}
+		if !bytes.Equal(resp.([]byte), data) {
+			t.Fatalf("echo mismatch")
+		}
+	}
+	for i := 0; i < 3; i++ { // hello 与两个请求
+		if flags := <-flagsSeen; flags != 0 {
+			t.Fatalf("packet %d sent with flags %#x to old server", i, flags)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package trontest
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"tron"
+)
+
+func TestFaultLatency(t *testing.T) {
+	fault := &tron.FaultConfig{
+		Latency: 5 * time.Millisecond,
+		Jitter:  5 * time.Millisecond,
+	}
+	pair, err := NewFaultPair(fault, fault, EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	data := strings.Repeat("x", 64)
+	resp, err := pair.Client.SyncWrite(tron.NewReqPacket([]byte(data)), 5*time.Second)
+	if err != nil {
+		t.Fatalf("sync write failed: %v", err)
+	}
+	if string(resp.([]byte)) != data {
+		t.Fatalf("invalid resp: %q", resp)
+	}
+}
+
+func TestFaultFragments(t *testing.T) {
+	fault := &tron.FaultConfig{
+		WriteChunk: 1,
+		ReadChunk:  3,
+	}
+	pair, err := NewFaultPair(fault, fault, EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	for _, data := range []string{"ping", strings.Repeat("x", 100)} {
+		resp, err := pair.Client.SyncWrite(tron.NewReqPacket([]byte(data)), 5*time.Second)
+		if err != nil {
+			t.Fatalf("sync write failed: %v", err)
+		}
+		if string(resp.([]byte)) != data {
+			t.Fatalf("invalid resp: %q", resp)
+		}
+	}
+}
+
+func TestFaultResetClosesSession(t *testing.T) {
+	pair, err := NewFaultPair(nil, &tron.FaultConfig{ResetAfter: 10}, EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	if _, err := pair.Client.SyncWrite(tron.NewReqPacket([]byte("ping")), 100*time.Millisecond); err == nil {
+		t.Fatalf("sync write should fail after reset")
+	}
+	waitClosed(t, pair.Client, "client session not closed after reset")
+}
+
+// 超过 PartialWrite 的写入只写出前一部分并返回 io.ErrShortWrite
+func TestFaultPartialWrite(t *testing.T) {
+	c, peer := net.Pipe()
+	defer peer.Close()
+	conn := tron.NewFaultConn(c, tron.FaultConfig{PartialWrite: 4})
+	defer conn.Close()
+
+	got := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 16)
+		n, _ := peer.Read(buf)
+		got <- string(buf[:n])
+	}()
+	n, err := conn.Write([]byte("0123456789"))
+	if n != 4 || err != io.ErrShortWrite {
+		t.Fatalf("want 4 bytes and io.ErrShortWrite, got %d %v", n, err)
+	}
+	if s := <-got; s != "0123" {
+		t.Fatalf("peer read %q, want %q", s, "0123")
+	}
+}
+
+// client 写入不完整时会话关闭，请求失败
+func TestFaultPartialWriteClosesSession(t *testing.T) {
+	pair, err := NewFaultPair(nil, &tron.FaultConfig{PartialWrite: 8}, EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	if _, err := pair.Client.SyncWrite(tron.NewReqPacket([]byte(strings.Repeat("x", 64))), 100*time.Millisecond); err == nil {
+		t.Fatal("sync write should fail after short write")
+	}
+	waitClosed(t, pair.Client, "client session not closed after short write")
+}
+
+// 读取量达到 StallAfter 后读阻塞 StallFor，之后恢复
+func TestFaultStallFor(t *testing.T) {
+	stall := 200 * time.Millisecond
+	pair, err := NewFaultPair(&tron.FaultConfig{StallAfter: 1, StallFor: stall}, nil, EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	start := time.Now()
+	for _, data := range []string{"ping", "pong"} {
+		resp, err := pair.Client.SyncWrite(tron.NewReqPacket([]byte(data)), 5*time.Second)
+		if err != nil {
+			t.Fatalf("sync write failed: %v", err)
+		}
+		if string(resp.([]byte)) != data {
+			t.Fatalf("invalid resp: %q", resp)
+		}
+	}
+	if d := time.Since(start); d < stall {
+		t.Fatalf("requests finished in %v, want stalled for at least %v", d, stall)
+	}
+}
+
+// StallFor 为 0 时读阻塞到连接关闭
+func TestFaultStallUntilClose(t *testing.T) {
+	c, peer := net.Pipe()
+	defer peer.Close()
+	conn := tron.NewFaultConn(c, tron.FaultConfig{StallAfter: 1})
+
+	go peer.Write([]byte("ab"))
+	buf := make([]byte, 1)
+	if n, err := conn.Read(buf); n != 1 || err != nil {
+		t.Fatalf("first read: %d %v", n, err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(buf)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("read returned %v before close", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	conn.Close()
+	select {
+	case err := <-done:
+		if err != net.ErrClosed {
+			t.Fatalf("want net.ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read still blocked after close")
+	}
+}
@@ -0,0 +1,64 @@
+package trontest
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+	"tron"
+)
+
+func TestFragmentInterleaved(t *testing.T) {
+	conf, err := tron.BuildConfig(tron.WithFragmentSize(16*1024), tron.WithChecksum(true))
+	if err != nil {
+		t.Fatalf("build config failed: %v", err)
+	}
+	pair, err := NewPairWithOptions([]tron.ServerOption{tron.WithServerConfig(conf)}, EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	cli, err := tron.Dial(context.Background(), pair.Listener.Addr().String(),
+		tron.WithClientHandler(NotifyHandler),
+		tron.WithClientConfig(conf),
+		tron.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return pair.Listener.Dial()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	if _, err := cli.SyncWrite(tron.NewReqPacket([]byte("hello")), time.Second); err != nil { // 等待协商完成
+		t.Fatalf("sync write failed: %v", err)
+	}
+
+	// 超过单个包的长度上限
+	big := bytes.Repeat([]byte("0123456789abcdef"), (tron.DEFAULT_MAX_PACKET_LEN+1024*1024)/16)
+	bigCh, err := cli.AsyncWrite(tron.NewReqPacket(big))
+	if err != nil {
+		t.Fatalf("async write failed: %v", err)
+	}
+
+	// 小请求不应排在大包之后
+	for i := 0; i < 3; i++ {
+		if _, err := cli.SyncWrite(tron.NewReqPacket([]byte("small")), time.Second); err != nil {
+			t.Fatalf("small request %d: %v", i, err)
+		}
+	}
+	select {
+	case <-bigCh:
+		t.Fatalf("small requests were blocked behind the large one")
+	default:
+	}
+
+	select {
+	case resp := <-bigCh:
+		if !bytes.Equal(resp.([]byte), big) {
+			t.Fatalf("large echo mismatch: got %d bytes, want %d", len(resp.([]byte)), len(big))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("large request timeout")
+	}
+}
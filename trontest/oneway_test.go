@@ -0,0 +1,120 @@
+package trontest
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tron"
+)
+
+func TestNotify(t *testing.T) {
+	for _, checksum := range []bool{false, true} { // 开启校验和时会协商 FLAG_ONEWAY
+		var notified, replied int64
+		pair, err := NewPair(func(worker *tron.Client, p *tron.Packet) {
+			if p.OneWay() {
+				atomic.AddInt64(&notified, 1)
+			}
+			EchoHandler(worker, p)
+		}, NotifyHandler)
+		if err != nil {
+			t.Fatalf("new pair failed: %v", err)
+		}
+		conf, err := tron.BuildConfig(tron.WithChecksum(checksum))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cli := dialConf(t, pair, conf, func(cli *tron.Client, p *tron.Packet) {
+			atomic.AddInt64(&replied, 1)
+		})
+
+		for i := 0; i < 50; i++ {
+			if err := cli.Notify([]byte("event")); err != nil {
+				t.Fatalf("notify failed: %v", err)
+			}
+		}
+		if _, err := cli.SyncWrite(tron.NewOneWayPacket([]byte("event")), time.Second); err != nil {
+			t.Fatalf("sync write one-way failed: %v", err)
+		}
+		eventually(2*time.Second, func() bool { return atomic.LoadInt64(&notified) >= 51 })
+		if n := atomic.LoadInt64(&notified); n != 51 {
+			t.Fatalf("checksum=%v: server got %d one-way messages, want 51", checksum, n)
+		}
+		if n := conf.SeqManager.Pending(); n != 0 {
+			t.Fatalf("checksum=%v: %d seqs pending after notify", checksum, n)
+		}
+		if n := atomic.LoadInt64(&replied); n != 0 {
+			t.Fatalf("checksum=%v: client got %d replies to one-way messages", checksum, n)
+		}
+		pair.Close()
+	}
+}
+
+// 超时的请求不再占用 SeqManager
+func TestSyncWriteTimeoutReleasesSeq(t *testing.T) {
+	pair, err := NewPair(func(worker *tron.Client, p *tron.Packet) {}, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+	conf, err := tron.BuildConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := dialConf(t, pair, conf, NotifyHandler)
+
+	if _, err := cli.SyncWrite(tron.NewReqPacket([]byte("ping")), 10*time.Millisecond); err == nil {
+		t.Fatal("want timeout")
+	}
+	if n := conf.SeqManager.Pending(); n != 0 {
+		t.Fatalf("%d seqs pending after timeout", n)
+	}
+}
+
+// server 原样回显单向消息时，client 的 handler 收到该包且不会 panic
+func TestNotifyNaiveEcho(t *testing.T) {
+	pair, err := NewPair(func(worker *tron.Client, p *tron.Packet) {
+		worker.AsyncWrite(tron.NewRespPacket(p.Header.Seq, p.Data))
+	}, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+	conf, err := tron.BuildConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var echoed int64
+	cli := dialConf(t, pair, conf, func(cli *tron.Client, p *tron.Packet) {
+		if p.OneWay() {
+			atomic.AddInt64(&echoed, 1)
+		}
+		NotifyHandler(cli, p)
+	})
+
+	if err := cli.Notify([]byte("event")); err != nil {
+		t.Fatalf("notify failed: %v", err)
+	}
+	eventually(2*time.Second, func() bool { return atomic.LoadInt64(&echoed) >= 1 })
+	if n := atomic.LoadInt64(&echoed); n != 1 {
+		t.Fatalf("client handler got %d echoed one-way messages, want 1", n)
+	}
+	if _, err := cli.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second); err != nil {
+		t.Fatalf("client unusable after echo: %v", err)
+	}
+}
+
+func dialConf(t *testing.T, pair *Pair, conf *tron.Config, f func(cli *tron.Client, p *tron.Packet)) *tron.Client {
+	cli, err := tron.Dial(context.Background(), pair.Listener.Addr().String(),
+		tron.WithClientConfig(conf),
+		tron.WithClientHandler(f),
+		tron.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return pair.Listener.Dial()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	return cli
+}
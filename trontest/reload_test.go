@@ -0,0 +1,127 @@
+package trontest
+
+import (
+	"testing"
+	"time"
+	"tron"
+)
+
+func TestServerReload(t *testing.T) {
+	pair, err := NewPair(EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	conf, err := tron.BuildConfig(tron.WithIdleDuration(10*time.Second), tron.WithReadChanSize(10))
+	if err != nil {
+		t.Fatalf("build config failed: %v", err)
+	}
+	report, err := pair.Server.Reload(tron.WithServerConfig(conf), tron.WithServerAddr("other"))
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if len(report.Applied) != 1 || report.Applied[0] != "IdleDuration" {
+		t.Fatalf("invalid applied settings: %v", report.Applied)
+	}
+	if len(report.NewConnsOnly) != 1 || report.NewConnsOnly[0] != "ReadChanSize" {
+		t.Fatalf("invalid new connection settings: %v", report.NewConnsOnly)
+	}
+	if len(report.RestartRequired) != 1 || report.RestartRequired[0] != "address" {
+		t.Fatalf("invalid restart settings: %v", report.RestartRequired)
+	}
+
+	// 存量连接不受影响
+	if _, err := pair.Client.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second); err != nil {
+		t.Fatalf("sync write after reload failed: %v", err)
+	}
+
+	if _, err := pair.Server.Reload(tron.WithServerKeepAlive(-1)); err == nil {
+		t.Fatalf("invalid reload should fail")
+	}
+
+	// 缩短的空闲时间对存量连接立即生效
+	conf, err = tron.BuildConfig(tron.WithIdleDuration(50*time.Millisecond), tron.WithReadChanSize(10))
+	if err != nil {
+		t.Fatalf("build config failed: %v", err)
+	}
+	if _, err := pair.Server.Reload(tron.WithServerConfig(conf)); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	waitClosed(t, pair.Client, "idle client not closed after reload")
+}
+
+// 由同一函数字面量创建、捕获状态不同的 handler 也会被替换
+func TestServerReloadFuncs(t *testing.T) {
+	tagHandler := func(tag string) func(worker *tron.Client, p *tron.Packet) {
+		return func(worker *tron.Client, p *tron.Packet) {
+			worker.AsyncWrite(tron.NewRespPacket(p.Header.Seq, []byte(tag)))
+		}
+	}
+	pair, err := NewPair(tagHandler("a"), NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	report, err := pair.Server.Reload(tron.WithServerHandler(tagHandler("b")))
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if len(report.NewConnsOnly) != 1 || report.NewConnsOnly[0] != "handler" {
+		t.Fatalf("invalid new connection settings: %v", report.NewConnsOnly)
+	}
+	cli, err := pair.NewClient(NotifyHandler)
+	if err != nil {
+		t.Fatalf("new client failed: %v", err)
+	}
+	defer cli.Close()
+	resp, err := cli.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second)
+	if err != nil || string(resp.([]byte)) != "b" {
+		t.Fatalf("new connection got %v %v, want reloaded handler", resp, err)
+	}
+
+	cmdFunc := func(cmd string) func(p *tron.Packet) string {
+		return func(p *tron.Packet) string { return cmd }
+	}
+	report, err = pair.Server.Reload(tron.WithServerCommandFunc(cmdFunc("get")))
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if len(report.Applied) != 1 || report.Applied[0] != "rateLimits" {
+		t.Fatalf("invalid applied settings: %v", report.Applied)
+	}
+
+	// 未指定 func 时不替换
+	if report, err = pair.Server.Reload(); err != nil || report.Changed() {
+		t.Fatalf("empty reload changed settings: %+v %v", report, err)
+	}
+}
+
+// 值不可比较的 codec
+type tagCodec struct {
+	tron.Codec
+	tags []string
+}
+
+func TestServerReloadUncomparableCodec(t *testing.T) {
+	pair, err := NewPair(EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	codec := tagCodec{Codec: tron.NewDefaultCodec()}
+	for i := 0; i < 2; i++ {
+		report, err := pair.Server.Reload(
+			tron.WithServerCodec(codec),
+			tron.WithServerListener(tron.ListenerConfig{Addr: "extra", Codec: codec}),
+		)
+		if err != nil {
+			t.Fatalf("reload failed: %v", err)
+		}
+		if len(report.NewConnsOnly) != 1 || report.NewConnsOnly[0] != "codec" {
+			t.Fatalf("round %d: invalid new connection settings: %v", i, report.NewConnsOnly)
+		}
+	}
+}
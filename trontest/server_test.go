@@ -0,0 +1,419 @@
+package trontest
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+	"tron"
+)
+
+func TestServerPortZero(t *testing.T) {
+	s, err := tron.NewServerWith("127.0.0.1:0", tron.WithServerHandler(EchoHandler))
+	if err != nil {
+		t.Fatalf("new server failed: %v", err)
+	}
+	if err := s.ListenAndServe(); err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	select {
+	case <-s.Ready():
+	case <-time.After(time.Second):
+		t.Fatalf("server not ready")
+	}
+
+	addr := s.Addr().String()
+	if tron.SplitPort(addr) == "0" {
+		t.Fatalf("port not resolved: %s", addr)
+	}
+
+	cli, err := tron.Dial(context.Background(), addr, tron.WithClientHandler(NotifyHandler))
+	if err != nil {
+		t.Fatalf("dial %s failed: %v", addr, err)
+	}
+	if _, err := cli.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second); err != nil {
+		t.Fatalf("sync write failed: %v", err)
+	}
+
+	s.Shutdown()
+	if err := s.Wait(); err != nil {
+		t.Fatalf("wait after shutdown: %v", err)
+	}
+}
+
+// 端口为 0 时所有 reuseport listener 共享同一个实际端口
+func TestServerReusePortZero(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT requires linux")
+	}
+	s, err := tron.NewServerWith("127.0.0.1:0",
+		tron.WithServerHandler(EchoHandler),
+		tron.WithServerReusePort(4),
+	)
+	if err != nil {
+		t.Fatalf("new server failed: %v", err)
+	}
+	if err := s.ListenAndServe(); err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	<-s.Ready()
+	defer s.Shutdown()
+
+	addrs := s.Addrs()
+	if len(addrs) != 4 {
+		t.Fatalf("want 4 listeners, got %v", addrs)
+	}
+	for _, addr := range addrs {
+		if addr.String() != addrs[0].String() || tron.SplitPort(addr.String()) == "0" {
+			t.Fatalf("listeners bound to different ports: %v", addrs)
+		}
+	}
+	for i := 0; i < 8; i++ {
+		cli, err := tron.Dial(context.Background(), addrs[0].String(), tron.WithClientHandler(NotifyHandler))
+		if err != nil {
+			t.Fatalf("dial %d failed: %v", i, err)
+		}
+		if _, err := cli.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second); err != nil {
+			t.Fatalf("sync write %d failed: %v", i, err)
+		}
+		cli.Close()
+	}
+}
+
+func TestServerMaxConns(t *testing.T) {
+	opts := []tron.ServerOption{
+		tron.WithServerMaxConns(1),
+		tron.WithServerLimitPolicy(tron.LIMIT_BUSY),
+	}
+	pair, err := NewPairWithOptions(opts, EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	cli, err := pair.NewClient(NotifyHandler)
+	if err != nil {
+		t.Fatalf("new client failed: %v", err)
+	}
+	waitClosed(t, cli, "client over limit not closed")
+
+	stats := pair.Server.Stats()
+	if stats.Active != 1 || stats.Accepted != 1 || stats.Rejected != 1 {
+		t.Fatalf("invalid stats: %+v", stats)
+	}
+	if _, err := pair.Client.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second); err != nil {
+		t.Fatalf("sync write on admitted client failed: %v", err)
+	}
+}
+
+func TestServerRateOverload(t *testing.T) {
+	opts := []tron.ServerOption{
+		tron.WithServerSessionRate(tron.RateLimit{Rate: 1, Burst: 1}),
+		tron.WithServerRateAction(tron.RATE_OVERLOAD),
+	}
+	pair, err := NewPairWithOptions(opts, EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	if _, err := pair.Client.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second); err != nil {
+		t.Fatalf("first sync write failed: %v", err)
+	}
+	if _, err := pair.Client.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second); err != tron.ERR_OVERLOAD {
+		t.Fatalf("second sync write should be overloaded, got %v", err)
+	}
+	if stats := pair.Server.Stats(); stats.RateOverloaded != 1 {
+		t.Fatalf("invalid stats: %+v", stats)
+	}
+}
+
+func TestServerEventLoop(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("event loop requires linux")
+	}
+	s, err := tron.NewServerWith("127.0.0.1:0",
+		tron.WithServerHandler(EchoHandler),
+		tron.WithServerEventLoop(2),
+	)
+	if err != nil {
+		t.Fatalf("new server failed: %v", err)
+	}
+	if err := s.ListenAndServe(); err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	<-s.Ready()
+	defer s.Shutdown()
+
+	// 默认 16KB 的内核缓冲区传输大包过慢
+	conf, err := tron.BuildConfig(tron.WithReadBufSize(1<<20), tron.WithWriteBufSize(1<<20))
+	if err != nil {
+		t.Fatalf("build config failed: %v", err)
+	}
+	var clis []*tron.Client
+	var conns []net.Conn
+	for i := 0; i < 8; i++ {
+		cli, err := tron.Dial(context.Background(), s.Addr().String(),
+			tron.WithClientHandler(NotifyHandler),
+			tron.WithClientConfig(conf),
+			tron.WithConnWrapper(func(conn net.Conn) net.Conn {
+				conns = append(conns, conn)
+				return conn
+			}),
+		)
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		clis = append(clis, cli)
+	}
+
+	// 大包跨越多次读取，需由 poller 暂存拼接
+	big := bytes.Repeat([]byte("0123456789"), 30*1024)
+	for i, cli := range clis {
+		for _, data := range [][]byte{[]byte("ping"), big} {
+			resp, err := cli.SyncWrite(tron.NewReqPacket(data), 2*time.Second)
+			if err != nil {
+				t.Fatalf("client %d: sync write failed: %v", i, err)
+			}
+			if !bytes.Equal(resp.([]byte), data) {
+				t.Fatalf("client %d: echo mismatch, got %d bytes want %d", i, len(resp.([]byte)), len(data))
+			}
+		}
+	}
+
+	conns[0].Close()
+	if !eventually(time.Second, func() bool { return s.NumSessions() == len(clis)-1 }) {
+		t.Fatalf("closed client not removed, %d sessions", s.NumSessions())
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	conf, err := tron.BuildConfig(tron.WithIdleDuration(100 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("build config failed: %v", err)
+	}
+	pair, err := NewPairWithOptions([]tron.ServerOption{tron.WithServerConfig(conf)}, EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	// 持续有请求的连接不会被关闭
+	for i := 0; i < 6; i++ {
+		if _, err := pair.Client.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second); err != nil {
+			t.Fatalf("sync write %d failed: %v", i, err)
+		}
+		time.Sleep(40 * time.Millisecond)
+	}
+
+	waitClosed(t, pair.Client, "idle client not closed")
+	if n := pair.Server.NumSessions(); n != 0 {
+		t.Fatalf("idle session not removed, %d sessions", n)
+	}
+}
+
+// Drain 等待进行中的请求完成、client 自行断开，超时后才强制关闭
+func TestServerDrain(t *testing.T) {
+	started := make(chan struct{}, 1)
+	handler := func(worker *tron.Client, p *tron.Packet) {
+		started <- struct{}{}
+		time.Sleep(200 * time.Millisecond)
+		worker.AsyncWrite(tron.NewRespPacket(p.Header.Seq, p.Data))
+	}
+	pair, err := NewPair(handler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := pair.Client.SyncWrite(tron.NewReqPacket([]byte("ping")), 2*time.Second)
+		pair.Client.Close()
+		done <- err
+	}()
+	<-started
+	start := time.Now()
+	if n := pair.Server.Drain(2 * time.Second); n != 0 {
+		t.Fatalf("drain force closed %d sessions", n)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("in-flight request failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("drain returned before in-flight request finished: %v", elapsed)
+	}
+}
+
+func TestServerDrainTimeout(t *testing.T) {
+	pair, err := NewPair(EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	if n := pair.Server.Drain(200 * time.Millisecond); n != 1 {
+		t.Fatalf("drain should force close the idle session, closed %d", n)
+	}
+	waitClosed(t, pair.Client, "client not closed after drain timeout")
+}
+
+// TCP 与 unix socket 同时监听，unix 上的连接使用独立的配置、codec 与连接上限
+func TestServerMultiListener(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket unsupported")
+	}
+	dir, err := ioutil.TempDir("", "trontest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "tron.sock")
+
+	conf, err := tron.BuildConfig(tron.WithIdleDuration(200 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("build config failed: %v", err)
+	}
+	s, err := tron.NewServerWith("127.0.0.1:0",
+		tron.WithServerHandler(EchoHandler),
+		tron.WithServerListener(tron.ListenerConfig{
+			Network:  "unix",
+			Addr:     sock,
+			Config:   conf,
+			Codec:    tron.NewDefaultCodec(tron.WithMaxPacketLen(1024)),
+			MaxConns: 1,
+		}),
+	)
+	if err != nil {
+		t.Fatalf("new server failed: %v", err)
+	}
+	if err := s.ListenAndServe(); err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	<-s.Ready()
+	defer s.Shutdown()
+
+	addrs := s.Addrs()
+	if len(addrs) != 2 || addrs[0].Network() != "tcp" || addrs[1].String() != sock {
+		t.Fatalf("invalid addrs: %v", addrs)
+	}
+	dialUnix := func() *tron.Client {
+		cli, err := tron.Dial(context.Background(), sock, tron.WithNetwork("unix"), tron.WithClientHandler(NotifyHandler))
+		if err != nil {
+			t.Fatalf("dial unix failed: %v", err)
+		}
+		return cli
+	}
+
+	// TCP 使用 server 的默认配置：大包可用，连接数不限
+	big := bytes.Repeat([]byte("x"), 4096)
+	var tcpClis []*tron.Client
+	for i := 0; i < 2; i++ {
+		cli, err := tron.Dial(context.Background(), addrs[0].String(), tron.WithClientHandler(NotifyHandler))
+		if err != nil {
+			t.Fatalf("dial tcp failed: %v", err)
+		}
+		defer cli.Close()
+		if _, err := cli.SyncWrite(tron.NewReqPacket(big), time.Second); err != nil {
+			t.Fatalf("tcp client %d: sync write failed: %v", i, err)
+		}
+		tcpClis = append(tcpClis, cli)
+	}
+
+	// unix 只允许一个连接
+	first := dialUnix()
+	defer first.Close()
+	if _, err := first.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second); err != nil {
+		t.Fatalf("unix sync write failed: %v", err)
+	}
+	second := dialUnix()
+	defer second.Close()
+	waitClosed(t, second, "unix client over MaxConns not closed")
+
+	// unix 上的 codec 拒绝超过 1024 字节的包
+	first.SyncWrite(tron.NewReqPacket(big), 200*time.Millisecond)
+	waitClosed(t, first, "unix client sending oversized packet not closed")
+
+	// unix 上的连接空闲 200ms 后被关闭，TCP 不受影响
+	idle := dialUnix()
+	defer idle.Close()
+	waitClosed(t, idle, "idle unix client not closed")
+	for i, cli := range tcpClis {
+		if _, err := cli.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second); err != nil {
+			t.Fatalf("tcp client %d closed with unix settings: %v", i, err)
+		}
+	}
+}
+
+// 不读取响应的对端写满 socket 缓冲后，同一 poller 上的其他连接不受影响
+func TestServerEventLoopSlowPeer(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("event loop requires linux")
+	}
+	dir, err := ioutil.TempDir("", "trontest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "tron.sock")
+
+	// unix socket 的缓冲固定，写满后写入即阻塞
+	s, err := tron.NewServerWith("",
+		tron.WithServerHandler(EchoHandler),
+		tron.WithServerListener(tron.ListenerConfig{Network: "unix", Addr: sock}),
+		tron.WithServerEventLoop(1),
+		tron.WithServerSessionRate(tron.RateLimit{Rate: 1, Burst: 1}),
+		tron.WithServerRateAction(tron.RATE_OVERLOAD), // 每个被丢弃的请求都由 poller 回复
+	)
+	if err != nil {
+		t.Fatalf("new server failed: %v", err)
+	}
+	if err := s.ListenAndServe(); err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	<-s.Ready()
+	defer s.Shutdown()
+
+	slow, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer slow.Close()
+	codec := tron.NewDefaultCodec()
+	var flood []byte
+	for i := 0; i < 4096; i++ {
+		p := tron.NewRespPacket(int32(i), []byte("ping"))
+		flood = append(flood, codec.MarshalPacket(*p)...)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := slow.Write(flood); err != nil {
+				return
+			}
+		}
+	}()
+
+	// 等待 slow 的请求远超 socket 缓冲能容纳的回复数
+	eventually(2*time.Second, func() bool { return s.Stats().RateOverloaded > 100000 })
+
+	cli, err := tron.Dial(context.Background(), sock, tron.WithNetwork("unix"), tron.WithClientHandler(NotifyHandler))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer cli.Close()
+	if _, err := cli.SyncWrite(tron.NewReqPacket([]byte("ping")), 2*time.Second); err != nil {
+		t.Fatalf("poller stalled by slow peer: %v", err)
+	}
+}
@@ -0,0 +1,317 @@
+package trontest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tron"
+)
+
+func dialStream(t *testing.T, opts ...tron.ServerOption) (*Pair, *tron.Client) {
+	pair, err := NewPairWithOptions(opts, EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	cli, err := tron.Dial(context.Background(), pair.Listener.Addr().String(),
+		tron.WithClientHandler(NotifyHandler),
+		tron.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return pair.Listener.Dial()
+		}),
+	)
+	if err != nil {
+		pair.Close()
+		t.Fatalf("dial failed: %v", err)
+	}
+	return pair, cli
+}
+
+func TestStream(t *testing.T) {
+	// 汇总 client 发送的数字，再逐行返回 0..sum-1
+	pair, cli := dialStream(t, tron.WithServerStreamHandler(func(st *tron.Stream) {
+		sum := 0
+		for st.Next() {
+			n, _ := strconv.Atoi(string(st.Data()))
+			sum += n
+		}
+		for i := 0; i < sum; i++ {
+			if err := st.Send([]byte(strconv.Itoa(i))); err != nil {
+				return
+			}
+		}
+		if sum == 0 {
+			st.Finish(42, "empty")
+		}
+	}))
+	defer pair.Close()
+
+	st, err := cli.OpenStream(context.Background())
+	if err != nil {
+		t.Fatalf("open stream failed: %v", err)
+	}
+	for _, n := range []string{"100", "200", "700"} {
+		if err := st.Send([]byte(n)); err != nil {
+			t.Fatalf("send failed: %v", err)
+		}
+	}
+	st.CloseSend()
+	if err := st.Send([]byte("1")); err != tron.ERR_STREAM_SEND_CLOSED {
+		t.Fatalf("send after close: %v", err)
+	}
+	rows := 0
+	for st.Next() {
+		if want := strconv.Itoa(rows); string(st.Data()) != want {
+			t.Fatalf("row %d: got %q", rows, st.Data())
+		}
+		rows++
+	}
+	if err := st.Err(); err != nil || rows != 1000 {
+		t.Fatalf("got %d rows, err %v", rows, err)
+	}
+
+	st, err = cli.OpenStream(context.Background())
+	if err != nil {
+		t.Fatalf("open stream failed: %v", err)
+	}
+	st.CloseSend()
+	for st.Next() {
+	}
+	serr, ok := st.Err().(*tron.StreamError)
+	if !ok || serr.Code != 42 || serr.Message != "empty" {
+		t.Fatalf("want status 42, got %v", st.Err())
+	}
+}
+
+func TestStreamUnimplemented(t *testing.T) {
+	pair, cli := dialStream(t)
+	defer pair.Close()
+
+	st, err := cli.OpenStream(context.Background())
+	if err != nil {
+		t.Fatalf("open stream failed: %v", err)
+	}
+	for st.Next() {
+	}
+	serr, ok := st.Err().(*tron.StreamError)
+	if !ok || serr.Code != tron.STATUS_UNIMPLEMENTED {
+		t.Fatalf("want unimplemented, got %v", fmt.Sprint(st.Err()))
+	}
+}
+
+// worker 打开的流 id 与 client 的冲突，只允许 client 打开
+func TestStreamOpenedByWorker(t *testing.T) {
+	errCh := make(chan error, 1)
+	pair, err := NewPair(func(worker *tron.Client, p *tron.Packet) {
+		_, err := worker.OpenStream(context.Background())
+		errCh <- err
+		EchoHandler(worker, p)
+	}, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+	cli := dialConf(t, pair, tron.NewDefaultConf(time.Minute), NotifyHandler)
+
+	if _, err := cli.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second); err != nil {
+		t.Fatalf("sync write failed: %v", err)
+	}
+	if err := <-errCh; err != tron.ERR_STREAM_WORKER {
+		t.Fatalf("worker open stream: want ERR_STREAM_WORKER, got %v", err)
+	}
+}
+
+// 不回复协商的旧版本 server，返回 ctx 的错误
+func TestStreamOpenTimeout(t *testing.T) {
+	cli, err := tron.Dial(context.Background(), "old-server",
+		tron.WithClientHandler(NotifyHandler),
+		tron.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, peer := net.Pipe()
+			go func() { // 读取并丢弃全部数据
+				buf := make([]byte, 1024)
+				for {
+					if _, err := peer.Read(buf); err != nil {
+						return
+					}
+				}
+			}()
+			return conn, nil
+		}),
+	)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := cli.OpenStream(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("want context.DeadlineExceeded, got %v", err)
+	}
+}
+
+// 不读取的流只阻塞自己的发送方，其他流照常完成
+func TestStreamFlowControl(t *testing.T) {
+	const rows, rowLen = 2048, 1024
+	var sent int64
+	pair, cli := dialStream(t, tron.WithServerStreamHandler(func(st *tron.Stream) {
+		if !st.Next() {
+			return
+		}
+		slow := string(st.Data()) == "slow"
+		row := make([]byte, rowLen)
+		for i := 0; i < rows; i++ {
+			if err := st.Send(row); err != nil {
+				return
+			}
+			if slow {
+				atomic.AddInt64(&sent, 1)
+			}
+		}
+	}))
+	defer pair.Close()
+
+	slow, err := cli.OpenStream(context.Background())
+	if err != nil {
+		t.Fatalf("open stream failed: %v", err)
+	}
+	slow.Send([]byte("slow"))
+
+	fast, err := cli.OpenStream(context.Background())
+	if err != nil {
+		t.Fatalf("open stream failed: %v", err)
+	}
+	fast.Send([]byte("fast"))
+	n := 0
+	for fast.Next() {
+		n++
+	}
+	if fast.Err() != nil || n != rows {
+		t.Fatalf("fast stream got %d rows, err %v", n, fast.Err())
+	}
+
+	// 发送方最多超出窗口一条消息
+	if got, max := atomic.LoadInt64(&sent), int64(tron.DEFAULT_STREAM_WINDOW/rowLen+1); got > max {
+		t.Fatalf("slow stream sent %d rows without being read, window allows %d", got, max)
+	}
+	n = 0
+	for slow.Next() {
+		n++
+	}
+	if slow.Err() != nil || n != rows {
+		t.Fatalf("slow stream got %d rows, err %v", n, slow.Err())
+	}
+}
+
+// 分片发送的消息之后紧跟的消息与 FIN 不会先于其剩余分片到达
+func TestStreamFragmentedThenFin(t *testing.T) {
+	conf, err := tron.BuildConfig(tron.WithFragmentSize(1024))
+	if err != nil {
+		t.Fatalf("build config failed: %v", err)
+	}
+	got := make(chan []string, 1)
+	pair, err := NewPairWithOptions([]tron.ServerOption{
+		tron.WithServerConfig(conf),
+		tron.WithServerStreamHandler(func(st *tron.Stream) {
+			var msgs []string
+			for st.Next() {
+				msgs = append(msgs, string(st.Data()))
+			}
+			got <- msgs
+		}),
+	}, EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+	cli, err := tron.Dial(context.Background(), pair.Listener.Addr().String(),
+		tron.WithClientHandler(NotifyHandler),
+		tron.WithClientConfig(conf),
+		tron.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return pair.Listener.Dial()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer cli.Close()
+
+	st, err := cli.OpenStream(context.Background())
+	if err != nil {
+		t.Fatalf("open stream failed: %v", err)
+	}
+	want := []string{
+		strings.Repeat("a", 10*1024),
+		"small",
+		strings.Repeat("b", 5*1024),
+	}
+	for _, msg := range want {
+		if err := st.Send([]byte(msg)); err != nil {
+			t.Fatalf("send failed: %v", err)
+		}
+	}
+	st.CloseSend()
+
+	select {
+	case msgs := <-got:
+		if len(msgs) != len(want) {
+			t.Fatalf("server received %d messages, want %d", len(msgs), len(want))
+		}
+		for i := range want {
+			if msgs[i] != want[i] {
+				t.Fatalf("message %d: got %d bytes %.8q, want %d bytes %.8q", i, len(msgs[i]), msgs[i], len(want[i]), want[i])
+			}
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("stream not finished")
+	}
+}
+
+// 流的 OPEN 与 FIN 不受限速影响，被丢弃的流消息不回复 overload
+func TestStreamRateLimited(t *testing.T) {
+	got := make(chan int, 1)
+	pair, cli := dialStream(t,
+		tron.WithServerSessionRate(tron.RateLimit{Rate: 0.001, Burst: 1}),
+		tron.WithServerRateAction(tron.RATE_OVERLOAD),
+		tron.WithServerStreamHandler(func(st *tron.Stream) {
+			n := 0
+			for st.Next() {
+				n++
+			}
+			got <- n
+		}),
+	)
+	defer pair.Close()
+	defer cli.Close()
+
+	st, err := cli.OpenStream(context.Background())
+	if err != nil {
+		t.Fatalf("open stream failed: %v", err)
+	}
+	for i := 0; i < 3; i++ { // 仅第一条消息有令牌
+		if err := st.Send([]byte("row")); err != nil {
+			t.Fatalf("send %d failed: %v", i, err)
+		}
+	}
+	st.CloseSend()
+
+	select {
+	case n := <-got:
+		if n != 1 {
+			t.Fatalf("server received %d messages, want 1", n)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("stream hung under rate limit")
+	}
+	for st.Next() {
+	}
+	if err := st.Err(); err != nil {
+		t.Fatalf("stream finished with %v", err)
+	}
+	if stats := pair.Server.Stats(); stats.RateOverloaded != 2 {
+		t.Fatalf("invalid stats: %+v", stats)
+	}
+}
@@ -0,0 +1,102 @@
+// trontest 提供基于内存管道的 Server / Client 测试辅助
+package trontest
+
+import (
+	"context"
+	"net"
+	"tron"
+)
+
+// 通过内存管道连接的 server 与 client
+type Pair struct {
+	Listener    *tron.LoopbackListener
+	Server      *tron.Server
+	Client      *tron.Client
+	clientFault *tron.FaultConfig
+}
+
+// 一次调用启动 server 并连接一个 client
+func NewPair(serverHandler func(worker *tron.Client, p *tron.Packet), clientHandler func(cli *tron.Client, p *tron.Packet)) (*Pair, error) {
+	return NewFaultPair(nil, nil, serverHandler, clientHandler)
+}
+
+// 同 NewPair，但 server 端与 client 端的连接分别注入故障，nil 表示不注入
+func NewFaultPair(serverFault, clientFault *tron.FaultConfig, serverHandler func(worker *tron.Client, p *tron.Packet), clientHandler func(cli *tron.Client, p *tron.Packet)) (*Pair, error) {
+	return newPair(serverFault, clientFault, nil, serverHandler, clientHandler)
+}
+
+// 同 NewPair，使用 opts 创建 server
+func NewPairWithOptions(opts []tron.ServerOption, serverHandler func(worker *tron.Client, p *tron.Packet), clientHandler func(cli *tron.Client, p *tron.Packet)) (*Pair, error) {
+	return newPair(nil, nil, opts, serverHandler, clientHandler)
+}
+
+func newPair(serverFault, clientFault *tron.FaultConfig, opts []tron.ServerOption, serverHandler func(worker *tron.Client, p *tron.Packet), clientHandler func(cli *tron.Client, p *tron.Packet)) (*Pair, error) {
+	l := tron.NewLoopbackListener("trontest")
+	opts = append([]tron.ServerOption{tron.WithServerHandler(serverHandler)}, opts...)
+	s, err := tron.NewServerWith(l.Addr().String(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var listener net.Listener = l
+	if serverFault != nil {
+		listener = tron.NewFaultListener(l, func(conn net.Conn) *tron.FaultConfig {
+			return serverFault
+		})
+	}
+	if err := s.Serve(listener); err != nil {
+		return nil, err
+	}
+
+	pair := &Pair{
+		Listener:    l,
+		Server:      s,
+		clientFault: clientFault,
+	}
+	cli, err := pair.NewClient(clientHandler)
+	if err != nil {
+		pair.Close()
+		return nil, err
+	}
+	pair.Client = cli
+	return pair, nil
+}
+
+// 向同一个 server 再连接一个 client
+func (p *Pair) NewClient(handler func(cli *tron.Client, p *tron.Packet)) (*tron.Client, error) {
+	opts := []tron.DialOption{
+		tron.WithClientHandler(handler),
+		tron.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return p.Listener.Dial()
+		}),
+	}
+	if p.clientFault != nil {
+		fault := *p.clientFault
+		opts = append(opts, tron.WithConnWrapper(func(conn net.Conn) net.Conn {
+			return tron.NewFaultConn(conn, fault)
+		}))
+	}
+	return tron.Dial(context.Background(), p.Listener.Addr().String(), opts...)
+}
+
+// 关闭 client、server 与 listener
+func (p *Pair) Close() {
+	if p.Client != nil {
+		p.Client.Close()
+	}
+	p.Server.Shutdown()
+	p.Listener.Close()
+}
+
+// 收到响应即通知等待中的请求，可直接作为 client handler
+func NotifyHandler(cli *tron.Client, p *tron.Packet) {
+	cli.NotifyReceived(p.Header.Seq, p.Data)
+}
+
+// 原样返回请求数据的 server handler
+func EchoHandler(worker *tron.Client, p *tron.Packet) {
+	if p.OneWay() {
+		return
+	}
+	worker.AsyncWrite(tron.NewRespPacket(p.Header.Seq, p.Data))
+}
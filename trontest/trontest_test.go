@@ -0,0 +1,75 @@
+package trontest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"tron"
+)
+
+func TestPairSyncWrite(t *testing.T) {
+	pair, err := NewPair(EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	resp, err := pair.Client.SyncWrite(tron.NewReqPacket([]byte("ping")), time.Second)
+	if err != nil {
+		t.Fatalf("sync write failed: %v", err)
+	}
+	if string(resp.([]byte)) != "ping" {
+		t.Fatalf("invalid resp: %q", resp)
+	}
+}
+
+func TestPairManyClients(t *testing.T) {
+	pair, err := NewPair(EchoHandler, NotifyHandler)
+	if err != nil {
+		t.Fatalf("new pair failed: %v", err)
+	}
+	defer pair.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		cli, err := pair.NewClient(NotifyHandler)
+		if err != nil {
+			t.Fatalf("new client failed: %v", err)
+		}
+		wg.Add(1)
+		go func(i int, cli *tron.Client) {
+			defer wg.Done()
+			data := fmt.Sprintf("client-%d", i)
+			resp, err := cli.SyncWrite(tron.NewReqPacket([]byte(data)), time.Second)
+			if err != nil {
+				t.Errorf("client %d sync write failed: %v", i, err)
+				return
+			}
+			if string(resp.([]byte)) != data {
+				t.Errorf("client %d invalid resp: %q", i, resp)
+			}
+		}(i, cli)
+	}
+	wg.Wait()
+}
+
+// 在 timeout 内轮询 cond 直到其成立，超时返回 false
+func eventually(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return true
+}
+
+// 等待 client 的会话在 1s 内关闭，否则以 msg 失败
+func waitClosed(t *testing.T, cli *tron.Client, msg string) {
+	t.Helper()
+	if !eventually(time.Second, cli.IsClosed) {
+		t.Fatal(msg)
+	}
+}